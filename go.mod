@@ -5,13 +5,16 @@ go 1.20
 require (
 	github.com/d2r2/go-i2c v0.0.0-20191123181816-73a8a799d6bc
 	github.com/d2r2/go-logger v0.0.0-20210606094344-60e9d1233e22
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/fogleman/gg v1.3.0
 	github.com/gen2brain/malgo v0.11.10
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/pelletier/go-toml v1.9.5
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stianeikeland/go-rpio/v4 v4.6.0
 	github.com/yelinaung/wifi-name v0.0.0-20181205043121-60d8acb81b8f
 	github.com/youpy/go-wav v0.3.2
+	go.etcd.io/bbolt v1.3.7
 	gobot.io/x/gobot v1.16.0
 )
 
@@ -22,8 +25,9 @@ require (
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.0.0 // indirect
-	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/youpy/go-riff v0.1.0 // indirect
 	github.com/zaf/g711 v0.0.0-20190814101024-76a4a538f52b // indirect
 	golang.org/x/image v0.8.0 // indirect
+	golang.org/x/net v0.6.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
 )
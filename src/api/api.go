@@ -1,10 +1,20 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"shazammini/src/audio"
+	"shazammini/src/export"
+	"shazammini/src/history"
 	"shazammini/src/structs"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pelletier/go-toml"
 	"gobot.io/x/gobot"
@@ -15,12 +25,250 @@ type Config struct {
 		Key string `toml:"key"`
 	} `toml:"Shazam"`
 	Spotify struct {
-		PlaylistID   string               `toml:"playlist_id"`
-		ClientID     string               `toml:"clientID"`
-		ClientSecret string               `toml:"clientSecret"`
-		TokenLogin   SpotifyTokenResponse `toml:"TokenLogin"`
-		TokenSearch  SpotifyTokenResponse `toml:"TokenSearch"`
+		PlaylistID     string  `toml:"playlist_id"`
+		ClientID       string  `toml:"clientID"`
+		ClientSecret   string  `toml:"clientSecret"`
+		Timeout        float64 `toml:"timeout"` // seconds before giving up on a request to Spotify
+		TokenFile      string  `toml:"token_file"`
+		PlaylistCache  string  `toml:"playlist_cache"`  // tracks of the playlist, used to skip duplicates
+		FavoritesID    string  `toml:"favorites_id"`    // playlist of the tracks saved with a long press
+		LikedSongs     bool    `toml:"liked_songs"`     // also save them to the Liked Songs
+		Undo           float64 `toml:"undo"`            // seconds after adding a track during which a tap removes it
+		Confirm        bool    `toml:"confirm"`         // pick the track among Spotify candidates before adding it
+		ConfirmTimeout float64 `toml:"confirm_timeout"` // seconds to pick it before nothing is added
+		MonthlyName    string  `toml:"monthly_name"`    // name of the monthly playlists, {month} becomes the year and month
+		MonthlyFile    string  `toml:"monthly_file"`    // ids of the monthly playlists by name
+		RedirectURI    string  `toml:"redirect_uri"`    // registered in the Spotify app, must be reachable from the phone logging in
+		CallbackAddr   string  `toml:"callback_addr"`   // address the login server listens on
+		LoginTimeout   float64 `toml:"login_timeout"`   // seconds to wait for the login before giving up
+		// tokens saved by older versions, moved to the token file
+		TokenLogin  SpotifyTokenResponse `toml:"TokenLogin"`
+		TokenSearch SpotifyTokenResponse `toml:"TokenSearch"`
 	} `toml:"Spotify"`
+	// Endpoints point the external services somewhere else, local stand-ins for testing
+	Endpoints struct {
+		Shazam          string `toml:"shazam"`           // RapidAPI Shazam host
+		SpotifyAccounts string `toml:"spotify_accounts"` // login and tokens
+		SpotifyAPI      string `toml:"spotify_api"`      // Web API, up to the version
+		Connectivity    string `toml:"connectivity"`     // answers 204 when the internet is reachable
+		Lastfm          string `toml:"lastfm"`           // Last.fm API root
+		ListenBrainz    string `toml:"listenbrainz"`     // ListenBrainz API root
+	} `toml:"Endpoints"`
+	Lastfm struct {
+		APIKey      string `toml:"api_key"`
+		Secret      string `toml:"secret"`
+		SessionFile string `toml:"session_file"` // session key saved by the lastfm auth command
+	} `toml:"Lastfm"`
+	ListenBrainz struct {
+		Token string `toml:"token"` // user token from the ListenBrainz settings
+	} `toml:"ListenBrainz"`
+	Webhooks struct {
+		Hooks      []Webhook `toml:"hooks"`
+		Attempts   int       `toml:"attempts"`    // posts of a recognition before it goes to the dead letter file
+		DeadLetter string    `toml:"dead_letter"` // recognitions no webhook accepted, one JSON per line
+	} `toml:"Webhooks"`
+	Email struct {
+		Host         string   `toml:"host"`
+		Port         int      `toml:"port"` // 587 by default
+		Username     string   `toml:"username"`
+		Password     string   `toml:"password"`
+		From         string   `toml:"from"`
+		To           []string `toml:"to"`
+		Security     string   `toml:"security"`     // starttls (default), tls or none
+		Recognitions bool     `toml:"recognitions"` // also send a notice for every recognition
+	} `toml:"Email"`
+	Digest struct {
+		Enabled  bool   `toml:"enabled"`
+		Time     string `toml:"time"`     // HH:MM the digest is sent at, 21:00 by default
+		Timezone string `toml:"timezone"` // such as Europe/Paris, the system timezone by default
+	} `toml:"Digest"`
+	History struct {
+		File  string `toml:"file"`  // every recognition and what became of it
+		Clips string `toml:"clips"` // directory the recordings are kept in, empty to keep none
+	} `toml:"History"`
+	Export struct {
+		Addr string `toml:"addr"` // address serving /export, such as :8081, empty to serve nothing
+	} `toml:"Export"`
+	MQTT struct {
+		Broker          string `toml:"broker"` // such as tcp://homeassistant.local:1883, empty to publish nothing
+		Username        string `toml:"username"`
+		Password        string `toml:"password"`
+		Topic           string `toml:"topic"`            // base of the topics, shazpi/<device name> by default
+		Discovery       bool   `toml:"discovery"`        // describe the device to Home Assistant
+		DiscoveryPrefix string `toml:"discovery_prefix"` // homeassistant by default
+	} `toml:"MQTT"`
+	Device struct {
+		Name string `toml:"name"` // told to the services ShazPi reports to, the host name by default
+	} `toml:"Device"`
+	Recognizer struct {
+		Backend     string  `toml:"backend"`     // shazam (default), local or fake
+		Fixture     string  `toml:"fixture"`     // JSON responses returned by the fake backend
+		Index       string  `toml:"index"`       // fingerprints of the library used by the local backend
+		MinScore    int     `toml:"min_score"`   // aligned landmarks needed by the local backend to match
+		Capture     float64 `toml:"capture"`     // seconds recorded after a touch
+		Window      float64 `toml:"window"`      // seconds of audio sent in each attempt, 0 sends the whole recording
+		Hop         float64 `toml:"hop"`         // seconds between the start of two windows
+		Concurrency int     `toml:"concurrency"` // windows recognized at the same time
+		Agreement   int     `toml:"agreement"`   // windows that must agree to stop early
+	} `toml:"Recognizer"`
+	Routing struct {
+		Rules []RouteRule `toml:"rules"` // evaluated in order, the first matching rule wins
+	} `toml:"Routing"`
+	Queue struct {
+		Dir   string  `toml:"dir"`   // where recordings made offline are kept
+		Retry float64 `toml:"retry"` // seconds between two connectivity checks
+	} `toml:"Queue"`
+	Simulator struct {
+		Enabled bool   `toml:"enabled"`
+		WAV     string `toml:"wav"`
+	} `toml:"Simulator"`
+}
+
+// MQTTDiscoveryPrefix is the topic prefix Home Assistant watches, empty when discovery is off
+func (cfg Config) MQTTDiscoveryPrefix() string {
+	if !cfg.MQTT.Discovery {
+		return ""
+	}
+	if cfg.MQTT.DiscoveryPrefix == "" {
+		return "homeassistant"
+	}
+	return cfg.MQTT.DiscoveryPrefix
+}
+
+// CaptureDuration is how long the microphone records for one recognition
+func (cfg Config) CaptureDuration() time.Duration {
+	if cfg.Recognizer.Capture <= 0 {
+		return 12 * time.Second
+	}
+	return seconds(cfg.Recognizer.Capture)
+}
+
+// FingerprintIndex is where the fingerprints of the library are stored for the local backend
+func (cfg Config) FingerprintIndex() string {
+	if cfg.Recognizer.Index == "" {
+		return "fingerprints.gob"
+	}
+	return cfg.Recognizer.Index
+}
+
+// FingerprintMinScore is the number of aligned landmarks the local backend needs to match
+func (cfg Config) FingerprintMinScore() int {
+	if cfg.Recognizer.MinScore <= 0 {
+		return 10
+	}
+	return cfg.Recognizer.MinScore
+}
+
+func (cfg Config) spotifyTokenFile() string {
+	if cfg.Spotify.TokenFile == "" {
+		return "spotify_tokens.toml"
+	}
+	return cfg.Spotify.TokenFile
+}
+
+func (cfg Config) playlistCacheFile() string {
+	if cfg.Spotify.PlaylistCache == "" {
+		return "playlist_cache.json"
+	}
+	return cfg.Spotify.PlaylistCache
+}
+
+func (cfg Config) undoWindow() time.Duration {
+	if cfg.Spotify.Undo <= 0 {
+		return 10 * time.Second
+	}
+	return seconds(cfg.Spotify.Undo)
+}
+
+func (cfg Config) spotifyTimeout() time.Duration {
+	if cfg.Spotify.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return seconds(cfg.Spotify.Timeout)
+}
+
+func (cfg Config) confirmTimeout() time.Duration {
+	if cfg.Spotify.ConfirmTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return seconds(cfg.Spotify.ConfirmTimeout)
+}
+
+func (cfg Config) monthlyPlaylists(client *spotifyClient) *monthlyPlaylists {
+	name, file := cfg.Spotify.MonthlyName, cfg.Spotify.MonthlyFile
+	if name == "" {
+		name = "ShazPi – {month}"
+	}
+	if file == "" {
+		file = "monthly_playlists.json"
+	}
+	return newMonthlyPlaylists(client, file, cfg.spotifyAPI(), name)
+}
+
+func (cfg Config) spotifyLogin() loginConfig {
+	login := loginConfig{
+		authorizeURL: cfg.spotifyAccounts() + "/authorize",
+		redirectURI:  cfg.Spotify.RedirectURI,
+		addr:         cfg.Spotify.CallbackAddr,
+		timeout:      seconds(cfg.Spotify.LoginTimeout),
+	}
+	if login.redirectURI == "" {
+		login.redirectURI = "http://shazpi.local:8080/callback"
+	}
+	if login.addr == "" {
+		login.addr = ":8080"
+	}
+	if login.timeout <= 0 {
+		login.timeout = 10 * time.Minute
+	}
+	return login
+}
+
+func (cfg Config) queueDir() string {
+	if cfg.Queue.Dir == "" {
+		return "queue"
+	}
+	return cfg.Queue.Dir
+}
+
+func (cfg Config) queueRetry() time.Duration {
+	if cfg.Queue.Retry <= 0 {
+		return 30 * time.Second
+	}
+	return seconds(cfg.Queue.Retry)
+}
+
+func (cfg Config) shazamEndpoint() string {
+	return endpoint(cfg.Endpoints.Shazam, "https://shazam.p.rapidapi.com")
+}
+
+func (cfg Config) spotifyAccounts() string {
+	return endpoint(cfg.Endpoints.SpotifyAccounts, "https://accounts.spotify.com")
+}
+
+func (cfg Config) spotifyAPI() string {
+	return endpoint(cfg.Endpoints.SpotifyAPI, "https://api.spotify.com/v1")
+}
+
+// ConnectivityURL is probed to know whether the internet is reachable
+func (cfg Config) ConnectivityURL() string {
+	return endpoint(cfg.Endpoints.Connectivity, "http://clients3.google.com/generate_204")
+}
+
+func endpoint(configured, fallback string) string {
+	if configured == "" {
+		return fallback
+	}
+	return strings.TrimSuffix(configured, "/")
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// LoadConfig reads creds.toml
+func LoadConfig() Config {
+	return Config{}.loadToml()
 }
 
 func (cfg Config) loadToml() Config {
@@ -37,54 +285,252 @@ func (cfg Config) loadToml() Config {
 
 	return cfg
 }
-func (cfg Config) saveToml() error {
-	// Create a TOML representation of the config struct
-	tomlData, err := toml.Marshal(cfg)
+
+func run(commChannels *structs.CommChannels) {
+
+	cfg := Config{}.loadToml()
+
+	recognizer := newRecognizer(cfg)
+
+	client := newSpotifyClient(cfg.spotifyTimeout())
+	tokens, err := newTokenManager(client, cfg.spotifyTokenFile(), cfg.spotifyAccounts()+"/api/token", cfg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal TOML: %v", err)
+		log.Fatalf("Could not load Spotify tokens: %v", err)
 	}
 
-	// Write the TOML data to a file
-	tomlFile := "creds.toml"
-	err = os.WriteFile(tomlFile, tomlData, 0644)
+	routes, err := newRouter(cfg.Routing.Rules, cfg.Spotify.PlaylistID)
 	if err != nil {
-		return fmt.Errorf("failed to write TOML file: %v", err)
+		log.Fatalf("Invalid playlist routing: %v", err)
 	}
 
-	return nil
-}
+	notify := newNotifier(cfg)
 
-func run(commChannels *structs.CommChannels) {
+	spotify := spotifyAPI{
+		add_playlist_url:    cfg.spotifyAPI() + "/playlists/{playlist_id}/tracks",
+		routes:              routes,
+		clientID:            cfg.Spotify.ClientID,
+		client:              client,
+		search_url:          cfg.spotifyAPI() + "/search",
+		remove_playlist_url: cfg.spotifyAPI() + "/playlists/{playlist_id}/tracks",
+		library_url:         cfg.spotifyAPI() + "/me/tracks",
+		liked_songs:         cfg.Spotify.LikedSongs,
+		favorites_id:        cfg.Spotify.FavoritesID,
+		tokens:              tokens,
+		playlists:           newPlaylistCache(client, cfg.playlistCacheFile(), cfg.spotifyAPI()),
+		monthly:             cfg.monthlyPlaylists(client),
+		login:               cfg.spotifyLogin(),
+		notify:              notify,
+		commChannels:        commChannels,
+	}
 
-	cfg := Config{}.loadToml()
+	// live recordings and the offline queue share the Spotify client
+	var lock sync.Mutex
+	process := func(rec *structs.Recognition) ([]playlistItem, error) {
+		lock.Lock()
+		defer lock.Unlock()
 
-	shazam := shazamAPI{
-		url:  "https://shazam.p.rapidapi.com/songs/v2/detect?timezone=Europe%2FParis&locale=fr-FR",
-		host: "shazam.p.rapidapi.com",
-		key:  cfg.Shazam.Key,
+		return spotify.AddSong(rec)
 	}
+	// in confirm mode live recordings wait for the user to pick the track
+	add := process
+	if cfg.Spotify.Confirm {
+		add = func(rec *structs.Recognition) ([]playlistItem, error) {
+			lock.Lock()
+			defer lock.Unlock()
 
-	spotify := spotifyAPI{
-		add_playlist_url: "https://api.spotify.com/v1/playlists/{playlist_id}/tracks?uris={track_ui}",
-		playlist_id:      cfg.Spotify.PlaylistID,
-		clientID:         cfg.Spotify.ClientID,
-		clientSecret:     cfg.Spotify.ClientSecret,
-		host:             "api.spotify.com",
-		search_url:       "https://api.spotify.com/v1/search?q={uri}&type=track",
-		data:             "{ 'uris': ['string'],'position': 0}",
-		token_login:      cfg.Spotify.TokenLogin,
-		token_search:     cfg.Spotify.TokenSearch,
+			return spotify.ConfirmSong(rec, cfg.confirmTimeout())
+		}
 	}
 
-	_ = spotify
+	store, err := history.Open(cfg.HistoryFile())
+	if err != nil {
+		log.Fatalf("Could not open history: %v", err)
+	}
 
-	for range commChannels.FetchAPI {
-		shazam.GetSong()
-		fmt.Print(shazam.response)
-		track := spotify.AddSong(&shazam.response)
-		commChannels.DisplayResult <- track
+	if cfg.Export.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/export", export.Handler(store))
+		go func() {
+			log.Println("Serving history exports on", cfg.Export.Addr)
+			log.Println("Export server stopped:", http.ListenAndServe(cfg.Export.Addr, mux))
+		}()
 	}
 
+	offline, err := newOfflineQueue(cfg.queueDir(), cfg.queueRetry())
+	if err != nil {
+		log.Fatalf("Could not open offline queue: %v", err)
+	}
+	var sinkList []Sink
+	if lastfm, err := newLastfmSink(cfg); err != nil {
+		log.Println("Not scrobbling to Last.fm:", err)
+	} else if lastfm != nil {
+		sinkList = append(sinkList, lastfm)
+	}
+	if listenBrainz := newListenBrainzSink(cfg); listenBrainz != nil {
+		sinkList = append(sinkList, listenBrainz)
+	}
+	sinkList = append(sinkList, newWebhookSinks(cfg)...)
+	if email := newEmailSink(cfg, notify); email != nil {
+		sinkList = append(sinkList, email)
+	}
+	sinks, err := newSinks(filepath.Join(cfg.queueDir(), "sinks"), cfg.queueRetry(), reportDelivery(store), sinkList...)
+	if err != nil {
+		log.Fatalf("Could not open sink queues: %v", err)
+	}
+
+	daily, err := newDigest(cfg, store, notify)
+	if err != nil {
+		log.Fatalf("Invalid digest: %v", err)
+	}
+	if daily != nil {
+		go daily.run()
+	}
+
+	// recognitions Spotify could not take for now stay in the offline queue
+	go offline.drain(recognizer, func(rec structs.Recognition) error {
+		if cfg.Spotify.Confirm {
+			// nobody is there to pick the track of a recording made earlier
+			remember(store, &rec, ErrNotConfirmed, "")
+			sinks.Deliver(rec)
+			return nil
+		}
+		_, err := process(&rec)
+		if temporary(err) {
+			return err
+		}
+		remember(store, &rec, err, "")
+		sinks.Deliver(rec)
+		return nil
+	})
+
+	// after a track is added, undo receives the taps that remove it again
+	// until undoTimeout, it is nil otherwise so that taps start recordings
+	var undo chan bool
+	var undoItems []playlistItem
+	var undoTimeout <-chan time.Time
+
+	// recognize handles a new recording and returns the recognition shown, if any
+	recognize := func() *structs.Recognition {
+		recordedAt := time.Now()
+		clip, err := audio.ReadWAV(audio.RecordingFile)
+		if err != nil {
+			log.Println("Could not read recording:", err)
+			return nil
+		}
+
+		rec, err := recognizer.Recognize(context.Background(), clip)
+		if errors.Is(err, ErrUnreachable) {
+			log.Println("Recognizer unreachable, queueing recording:", err)
+			waiting, err := offline.add(clip, recordedAt)
+			if err != nil {
+				log.Println("Could not queue recording:", err)
+				commChannels.Status.SetState(structs.StateError, "Offline, recording lost")
+				commChannels.DisplayMessage <- "Offline, recording lost"
+				return nil
+			}
+			commChannels.Status.SetState(structs.StateIdle, "")
+			commChannels.DisplayMessage <- fmt.Sprintf("Queued, %d waiting for internet", waiting)
+			return nil
+		}
+		if err != nil {
+			log.Println("Could not recognize song:", err)
+			commChannels.Status.SetState(structs.StateError, "No match found")
+			commChannels.DisplayResult <- structs.Recognition{Track: structs.Track{Title: "No match found"}}
+			return nil
+		}
+		if rec.Timestamp == 0 {
+			rec.Timestamp = recordedAt.UnixMilli()
+		}
+		log.Printf("Recognized %s by %s (%s)", rec.Track.Title, rec.Track.Subtitle, rec.Backend)
+		commChannels.Status.SetResult(rec)
+
+		// sinks are told once the Spotify track is known, whether it was added or not
+		added, err := addRecognition(commChannels.Status, &rec, add)
+		remember(store, &rec, err, cfg.keepClip(clip, recordedAt))
+		sinks.Deliver(rec)
+		if errors.Is(err, ErrNotConfirmed) {
+			commChannels.DisplayMessage <- "Nothing added"
+			return nil
+		}
+		if errors.Is(err, ErrAlreadyInPlaylist) {
+			commChannels.DisplayMessage <- fmt.Sprintf("%s already in playlist", rec.Track.Title)
+			return &rec
+		}
+		if err != nil {
+			log.Println("Could not add song to Spotify:", err)
+			commChannels.Status.SetState(structs.StateError, "Not added, "+displayError(err))
+			commChannels.DisplayMessage <- fmt.Sprintf("%s not added, %s", rec.Track.Title, displayError(err))
+			return &rec
+		}
+		commChannels.DisplayResult <- rec
+
+		if len(added) > 0 {
+			undo = commChannels.Undo
+			undoItems = added
+			undoTimeout = time.After(cfg.undoWindow())
+		}
+		return &rec
+	}
+
+	// the result or error of a recording is the state until settle, as
+	// long as the undo window
+	var settle <-chan time.Time
+
+	// the recognition on screen, a long press saves it to the favorites
+	var last *structs.Recognition
+	for {
+		select {
+		case <-commChannels.FetchAPI:
+			undo, undoItems, undoTimeout = nil, nil, nil
+			last = recognize()
+			settle = time.After(cfg.undoWindow())
+		case <-settle:
+			settle = nil
+			commChannels.Status.Settle()
+		case <-undoTimeout:
+			undo, undoItems, undoTimeout = nil, nil, nil
+		case <-undo:
+			lock.Lock()
+			err := spotify.RemoveSong(undoItems)
+			lock.Unlock()
+			undo, undoItems, undoTimeout = nil, nil, nil
+			if err != nil {
+				log.Println("Could not remove song:", err)
+				commChannels.DisplayMessage <- "Could not remove, " + displayError(err)
+				continue
+			}
+			if last != nil && last.ID != 0 {
+				if err := store.SetOutcome(last.ID, history.Removed, ""); err != nil {
+					log.Println("Could not record removal in the history:", err)
+				}
+			}
+			last = nil
+			commChannels.DisplayMessage <- "Removed"
+		case <-commChannels.Favorite:
+			if last == nil {
+				continue
+			}
+			lock.Lock()
+			err := spotify.SaveFavorite(*last)
+			lock.Unlock()
+			if err != nil {
+				log.Println("Could not save favorite:", err)
+				commChannels.DisplayMessage <- "Could not save to favorites, " + displayError(err)
+				continue
+			}
+			commChannels.DisplayMessage <- fmt.Sprintf("%s saved to favorites", last.Track.Title)
+		}
+	}
+}
+
+// addRecognition adds the recognition with add, then tells the watchers of
+// the status about the Spotify track found for it
+func addRecognition(status *structs.StatusBus, rec *structs.Recognition, add func(*structs.Recognition) ([]playlistItem, error)) ([]playlistItem, error) {
+	added, err := add(rec)
+	if rec.SpotifyURI != "" {
+		status.SetTrack(*rec)
+	}
+	return added, err
 }
 
 func Api(commChannels *structs.CommChannels) *gobot.Robot {
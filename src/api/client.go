@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spotifyRetries is how many times a request is sent again when Spotify is overloaded
+	spotifyRetries = 3
+	// spotifyBackoff is the longest first wait before sending a request again, it doubles every retry
+	spotifyBackoff = 500 * time.Millisecond
+	// spotifyMaxRetryAfter is the longest Retry-After waited for, longer ones fail right away
+	spotifyMaxRetryAfter = 30 * time.Second
+)
+
+// ErrSpotifyUnreachable is returned when Spotify did not answer
+var ErrSpotifyUnreachable = errors.New("Spotify unreachable")
+
+// SpotifyError is an answer of Spotify that is not a success
+type SpotifyError struct {
+	Status  int
+	Message string
+	// RetryAfter is how long Spotify asked to wait when rate limiting
+	RetryAfter time.Duration
+}
+
+func (e *SpotifyError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Spotify answered %d", e.Status)
+	}
+	return fmt.Sprintf("Spotify answered %d: %s", e.Status, e.Message)
+}
+
+// spotifyClient is shared by every call to Spotify, it gives up on requests
+// that take too long and retries those that failed because Spotify was
+// overloaded or rate limiting. Requests adding to Spotify are only retried
+// when Spotify surely did not carry them out
+type spotifyClient struct {
+	http *http.Client
+}
+
+func newSpotifyClient(timeout time.Duration) *spotifyClient {
+	return &spotifyClient{http: &http.Client{Timeout: timeout}}
+}
+
+// Do sends the request and returns the body of a successful response
+func (c *spotifyClient) Do(method, u string, header http.Header, body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header.Clone()
+
+		wait, data, err := c.send(req, attempt)
+		if wait < 0 || attempt == spotifyRetries {
+			return data, err
+		}
+		log.Printf("Spotify request failed, retrying in %s: %v", wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+	}
+}
+
+// send returns how long to wait before retrying, or a negative wait when the
+// request must not be retried
+func (c *spotifyClient) send(req *http.Request, attempt int) (time.Duration, []byte, error) {
+	// Spotify may have carried out a request that timed out or failed on
+	// its side, only those doing the same when sent twice are sent again
+	lost := backoff(attempt)
+	if !idempotent(req.Method) {
+		lost = -1
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return lost, nil, fmt.Errorf("%w: %v", ErrSpotifyUnreachable, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return lost, nil, fmt.Errorf("%w: %v", ErrSpotifyUnreachable, err)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return -1, data, nil
+	}
+
+	e := &SpotifyError{Status: res.StatusCode, Message: errorMessage(data)}
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		e.RetryAfter = retryAfter(res.Header.Get("Retry-After"))
+		if e.RetryAfter > spotifyMaxRetryAfter {
+			return -1, nil, e
+		}
+		return e.RetryAfter, nil, e
+	case res.StatusCode == http.StatusServiceUnavailable:
+		// refused before being carried out
+		return backoff(attempt), nil, e
+	case res.StatusCode >= 500:
+		return lost, nil, e
+	}
+	return -1, nil, e
+}
+
+// idempotent tells whether sending the request twice does the same as
+// sending it once, adding tracks or creating playlists does not
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// backoff waits a random time up to a limit doubling with every attempt, so
+// that devices failing together do not retry together
+func backoff(attempt int) time.Duration {
+	return time.Duration(rand.Int63n(int64(spotifyBackoff << attempt)))
+}
+
+// retryAfter reads the Retry-After header, in seconds for Spotify
+func retryAfter(header string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || secs < 0 {
+		return time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// errorMessage extracts the explanation from the body of an error, the Web
+// API and the accounts service format it differently
+func errorMessage(data []byte) string {
+	var web struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &web) == nil && web.Error.Message != "" {
+		return web.Error.Message
+	}
+	var accounts struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(data, &accounts) == nil && accounts.Error != "" {
+		if accounts.Description != "" {
+			return accounts.Error + ": " + accounts.Description
+		}
+		return accounts.Error
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// getJSON calls the Spotify Web API and decodes the response into v
+func (c *spotifyClient) getJSON(token, u string, v interface{}) error {
+	return c.callJSON("GET", token, u, nil, v)
+}
+
+// callJSON sends in as the JSON body of the request when set and decodes the response into out
+func (c *spotifyClient) callJSON(method, token, u string, in interface{}, out interface{}) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	data, err := c.Do(method, u, header, body)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// temporary tells whether a Spotify failure may go away by itself, Spotify
+// being unreachable, overloaded or rate limiting
+func temporary(err error) bool {
+	var spotifyErr *SpotifyError
+	if errors.As(err, &spotifyErr) {
+		return spotifyErr.Status == http.StatusTooManyRequests || spotifyErr.Status >= 500
+	}
+	return errors.Is(err, ErrSpotifyUnreachable)
+}
+
+// displayError is the short explanation of a Spotify failure shown on the display
+func displayError(err error) string {
+	var spotifyErr *SpotifyError
+	var noMatch *NoConfidentMatchError
+	switch {
+	case errors.As(err, &noMatch):
+		return "not found on Spotify"
+	case errors.Is(err, ErrLoginTimeout):
+		return "Spotify login timed out"
+	case errors.Is(err, ErrNoFavorites):
+		return "favorites not set up"
+	case errors.Is(err, ErrNotLoggedIn):
+		return "not logged in to Spotify"
+	case errors.Is(err, ErrSpotifyUnreachable):
+		return "Spotify unreachable"
+	case errors.As(err, &spotifyErr):
+		switch {
+		case spotifyErr.Status == http.StatusTooManyRequests:
+			return "Spotify is busy, try later"
+		case spotifyErr.Status == http.StatusUnauthorized || spotifyErr.Status == http.StatusForbidden:
+			return "Spotify refused access"
+		case spotifyErr.Status == http.StatusNotFound:
+			return "playlist not found"
+		case spotifyErr.Status >= 500:
+			return "Spotify is down"
+		}
+	}
+	return "Spotify error"
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// spotifyStandIn answers the requests it gets one after the other with
+// answers, repeating the last one
+func spotifyStandIn(t *testing.T, answers ...func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *int32) {
+	calls := new(int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n > len(answers) {
+			n = len(answers)
+		}
+		answers[n-1](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func answer(status int, header, body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if header != "" {
+			w.Header().Set("Retry-After", header)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
+
+// hangUp closes the connection without answering
+func hangUp(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestSpotifyClientRetries(t *testing.T) {
+	ok := answer(http.StatusOK, "", `{"ok":true}`)
+	tests := []struct {
+		name      string
+		method    string
+		answers   []func(w http.ResponseWriter, r *http.Request)
+		wantCalls int
+		wantErr   int // status of the SpotifyError, 0 for none
+		wantWait  time.Duration
+		lost      bool // want ErrSpotifyUnreachable
+	}{
+		{"success", "GET", []func(http.ResponseWriter, *http.Request){ok}, 1, 0, 0, false},
+		{"429 waits for Retry-After", "GET", []func(http.ResponseWriter, *http.Request){answer(429, "1", ""), ok}, 2, 0, time.Second, false},
+		{"429 with a long Retry-After fails", "GET", []func(http.ResponseWriter, *http.Request){answer(429, "3600", ""), ok}, 1, 429, 0, false},
+		{"5xx backs off then succeeds", "GET", []func(http.ResponseWriter, *http.Request){answer(502, "", ""), answer(504, "", ""), ok}, 3, 0, 0, false},
+		{"POST is retried after 503 and 429", "POST", []func(http.ResponseWriter, *http.Request){answer(503, "", ""), answer(429, "0", ""), ok}, 3, 0, 0, false},
+		{"POST is not retried after 500", "POST", []func(http.ResponseWriter, *http.Request){answer(500, "", ""), ok}, 1, 500, 0, false},
+		{"POST is not retried after 502", "POST", []func(http.ResponseWriter, *http.Request){answer(502, "", ""), ok}, 1, 502, 0, false},
+		{"retries are capped", "GET", []func(http.ResponseWriter, *http.Request){answer(500, "", "")}, spotifyRetries + 1, 500, 0, false},
+		{"4xx is not retried", "GET", []func(http.ResponseWriter, *http.Request){answer(404, "", ""), ok}, 1, 404, 0, false},
+		{"lost GET is retried", "GET", []func(http.ResponseWriter, *http.Request){hangUp, ok}, 2, 0, 0, false},
+		{"lost POST is not retried", "POST", []func(http.ResponseWriter, *http.Request){hangUp, ok}, 1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := spotifyStandIn(t, tt.answers...)
+			client := newSpotifyClient(5 * time.Second)
+
+			start := time.Now()
+			data, err := client.Do(tt.method, srv.URL, http.Header{}, []byte("{}"))
+			if waited := time.Since(start); waited < tt.wantWait {
+				t.Errorf("retried after %s, want at least %s", waited, tt.wantWait)
+			}
+			if got := int(atomic.LoadInt32(calls)); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+
+			var spotifyErr *SpotifyError
+			switch {
+			case tt.lost:
+				if !errors.Is(err, ErrSpotifyUnreachable) {
+					t.Errorf("got %v, want %v", err, ErrSpotifyUnreachable)
+				}
+			case tt.wantErr == 0:
+				if err != nil || string(data) != `{"ok":true}` {
+					t.Errorf("got %q, %v", data, err)
+				}
+			case !errors.As(err, &spotifyErr):
+				t.Errorf("got %v, want a SpotifyError", err)
+			case spotifyErr.Status != tt.wantErr:
+				t.Errorf("got status %d, want %d", spotifyErr.Status, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSpotifyClientRetryAfter(t *testing.T) {
+	srv, _ := spotifyStandIn(t, answer(429, "3600", `{"error":{"status":429,"message":"API rate limit exceeded"}}`))
+	_, err := newSpotifyClient(time.Second).Do("GET", srv.URL, http.Header{}, nil)
+
+	var spotifyErr *SpotifyError
+	if !errors.As(err, &spotifyErr) {
+		t.Fatalf("got %v, want a SpotifyError", err)
+	}
+	if spotifyErr.RetryAfter != time.Hour {
+		t.Errorf("got Retry-After %s, want 1h", spotifyErr.RetryAfter)
+	}
+	if spotifyErr.Message != "API rate limit exceeded" {
+		t.Errorf("got message %q", spotifyErr.Message)
+	}
+}
+
+func TestSpotifyClientTimeout(t *testing.T) {
+	srv, calls := spotifyStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	_, err := newSpotifyClient(50*time.Millisecond).Do("POST", srv.URL, http.Header{}, nil)
+	if !errors.Is(err, ErrSpotifyUnreachable) {
+		t.Errorf("got %v, want %v", err, ErrSpotifyUnreachable)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("timed out POST sent %d times, want once", got)
+	}
+}
+
+func TestSpotifyErrorMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"web API", `{"error":{"status":404,"message":"Invalid playlist Id"}}`, "Invalid playlist Id"},
+		{"accounts", `{"error":"invalid_grant","error_description":"Refresh token revoked"}`, "invalid_grant: Refresh token revoked"},
+		{"accounts without description", `{"error":"invalid_client"}`, "invalid_client"},
+		{"not JSON", " Bad gateway\n", "Bad gateway"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := spotifyStandIn(t, answer(http.StatusBadRequest, "", tt.body))
+			_, err := newSpotifyClient(time.Second).Do("GET", srv.URL, http.Header{}, nil)
+
+			var spotifyErr *SpotifyError
+			if !errors.As(err, &spotifyErr) {
+				t.Fatalf("got %v, want a SpotifyError", err)
+			}
+			if spotifyErr.Message != tt.want {
+				t.Errorf("got %q, want %q", spotifyErr.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenRequest(t *testing.T) {
+	srv, _ := spotifyStandIn(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			if user, secret, ok := r.BasicAuth(); !ok || user != "id" || secret != "secret" {
+				t.Errorf("token requested as %q:%q", user, secret)
+			}
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+				t.Errorf("got form %v", r.PostForm)
+			}
+			fmt.Fprint(w, `{"access_token":"new","token_type":"Bearer","expires_in":3600}`)
+		},
+		answer(http.StatusBadRequest, "", `{"error":"invalid_grant","error_description":"Refresh token revoked"}`),
+	)
+	m := &tokenManager{client: newSpotifyClient(time.Second), tokenURL: srv.URL, clientID: "id", clientSecret: "secret"}
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"old"}}
+
+	token, err := m.request(form, true)
+	if err != nil || token.AccessToken != "new" || token.ExpiresIn != 3600 {
+		t.Fatalf("got %+v, %v", token, err)
+	}
+
+	_, err = m.request(form, true)
+	var spotifyErr *SpotifyError
+	if !errors.As(err, &spotifyErr) || spotifyErr.Message != "invalid_grant: Refresh token revoked" {
+		t.Errorf("got %v, want the accounts error", err)
+	}
+}
+
+func TestDisplayError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&NoConfidentMatchError{Title: "Song", Artist: "Band"}, "not found on Spotify"},
+		{ErrLoginTimeout, "Spotify login timed out"},
+		{ErrNoFavorites, "favorites not set up"},
+		{fmt.Errorf("could not refresh: %w", ErrNotLoggedIn), "not logged in to Spotify"},
+		{fmt.Errorf("%w: connection refused", ErrSpotifyUnreachable), "Spotify unreachable"},
+		{&SpotifyError{Status: 429}, "Spotify is busy, try later"},
+		{fmt.Errorf("search: %w", &SpotifyError{Status: 401}), "Spotify refused access"},
+		{&SpotifyError{Status: 403}, "Spotify refused access"},
+		{&SpotifyError{Status: 404}, "playlist not found"},
+		{&SpotifyError{Status: 503}, "Spotify is down"},
+		{&SpotifyError{Status: 400}, "Spotify error"},
+		{errors.New("something else"), "Spotify error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := displayError(tt.err); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
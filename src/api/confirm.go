@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"shazammini/src/structs"
+	"time"
+)
+
+// confirmCandidates is the number of Spotify tracks offered in confirm mode
+const confirmCandidates = 3
+
+// ErrNotConfirmed is returned when no track was picked in confirm mode
+var ErrNotConfirmed = errors.New("no track picked")
+
+// ConfirmSong shows the best Spotify candidates for the recognition and adds
+// the one tapped on the touch screen, nothing is added when "None" is picked
+// or nobody answers within timeout. The other Shazam matches are not offered,
+// they are bare IDs the detect endpoint cannot turn into tracks
+func (s *spotifyAPI) ConfirmSong(rec *structs.Recognition, timeout time.Duration) ([]playlistItem, error) {
+	if err := s.EstablishAcces(); err != nil {
+		return nil, err
+	}
+
+	candidates, err := s.Candidates(&rec.Track, songPosition(*rec), confirmCandidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		s.notify.Unmatched(*rec)
+		return nil, &NoConfidentMatchError{Title: rec.Track.Title, Artist: mainArtist(&rec.Track)}
+	}
+	if len(candidates) > confirmCandidates {
+		candidates = candidates[:confirmCandidates]
+	}
+
+	options := make([]string, 0, len(candidates)+1)
+	for _, c := range candidates {
+		options = append(options, c.describe())
+	}
+	options = append(options, "None")
+	s.commChannels.DisplayChoice <- options
+
+	choice := structs.Choice{
+		Options:  len(options),
+		Deadline: time.Now().Add(timeout),
+		Reply:    make(chan int, 1),
+	}
+	s.commChannels.Choose <- choice
+
+	select {
+	case picked := <-choice.Reply:
+		if picked >= len(candidates) {
+			return nil, ErrNotConfirmed
+		}
+		rec.SpotifyURI = candidates[picked].Uri
+		return s.AddTrack(*rec, candidates[picked].Uri)
+	case <-time.After(timeout):
+		return nil, ErrNotConfirmed
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"shazammini/src/history"
+	"time"
+)
+
+// digestTrack is a line of the digest email
+type digestTrack struct {
+	Time     string
+	Title    string
+	Artist   string
+	CoverArt string
+	Outcome  string
+	Added    bool
+}
+
+// digestMark is where the history was at the last digest sent
+const digestMark = "digest"
+
+// digest emails the recognitions added to the history since the previous
+// digest once a day. Those added while the email is being sent wait for the
+// next digest
+type digest struct {
+	history  *history.Store
+	at       time.Duration // since midnight
+	location *time.Location
+	retry    time.Duration
+	notify   *notifier
+}
+
+// newDigest returns nil when the digest is not enabled
+func newDigest(cfg Config, store *history.Store, notify *notifier) (*digest, error) {
+	if !cfg.Digest.Enabled {
+		return nil, nil
+	}
+	if notify == nil {
+		return nil, errors.New("the digest is sent by email, set up the Email section")
+	}
+
+	at := 21 * time.Hour
+	if cfg.Digest.Time != "" {
+		t, err := time.Parse("15:04", cfg.Digest.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest time %q, expected HH:MM", cfg.Digest.Time)
+		}
+		at = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	location := time.Local
+	if cfg.Digest.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.Digest.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest timezone: %w", err)
+		}
+	}
+
+	return &digest{
+		history:  store,
+		at:       at,
+		location: location,
+		retry:    cfg.queueRetry(),
+		notify:   notify,
+	}, nil
+}
+
+// run sends the digest every day at the configured time, and again a little
+// later when sending failed
+func (d *digest) run() {
+	next := nextDigest(time.Now(), d.at, d.location)
+	for {
+		time.Sleep(time.Until(next))
+
+		if err := d.send(next); err != nil {
+			log.Println("Could not send the digest:", err)
+			next = time.Now().Add(d.retry)
+			continue
+		}
+		next = nextDigest(time.Now(), d.at, d.location)
+	}
+}
+
+// nextDigest is the first time after now that is at since midnight in location
+func nextDigest(now time.Time, at time.Duration, location *time.Location) time.Time {
+	// the hour is set rather than added to midnight, days are not all 24h long
+	local := now.In(location)
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, location)
+	if !next.After(local) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, location)
+	}
+	return next
+}
+
+// send emails the entries added since the last digest and marks them as
+// sent, nothing is sent when nothing was recognized
+func (d *digest) send(day time.Time) error {
+	last, err := d.history.Marked(digestMark)
+	if err != nil {
+		return err
+	}
+	q := history.Query{After: last}
+	if last == 0 {
+		// first digest, the history may go back further than a day
+		q.From = day.AddDate(0, 0, -1)
+	}
+	entries, err := d.history.Query(q)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	data := noticeData{
+		Day:    day.In(d.location).Format("Monday 2 January"),
+		Count:  len(entries),
+		Tracks: make([]digestTrack, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		rec := entry.Recognition
+		track := digestTrack{
+			Time:     entry.RecordedAt.In(d.location).Format("15:04"),
+			Title:    rec.Track.Title,
+			Artist:   rec.Track.Subtitle,
+			CoverArt: rec.Track.Image.CoverArt,
+			Added:    entry.Outcome == history.Added,
+		}
+		switch entry.Outcome {
+		case history.Added:
+			track.Outcome = "added to Spotify"
+			data.Added++
+		case history.Duplicate:
+			track.Outcome = "already in playlist"
+		case history.Skipped:
+			track.Outcome = "not picked"
+		case history.Removed:
+			track.Outcome = "removed again"
+		default:
+			track.Outcome = "not added, " + entry.Error
+			data.Failed++
+		}
+		data.Tracks = append(data.Tracks, track)
+	}
+	if err := d.notify.send(digestNotice, data); err != nil {
+		return err
+	}
+	return d.history.Mark(digestMark, entries[len(entries)-1].ID)
+}
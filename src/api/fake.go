@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+	"sync"
+)
+
+// fakeRecognizer answers with responses read from a fixture file instead of
+// calling an API. The fixture holds one Shazam detect response or an array of
+// them, which are returned in turn
+type fakeRecognizer struct {
+	lock      sync.Mutex
+	responses []ShazamResponse
+	next      int
+}
+
+func newFakeRecognizer(fixture string) (*fakeRecognizer, error) {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fakeRecognizer{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &f.responses)
+	} else {
+		f.responses = make([]ShazamResponse, 1)
+		err = json.Unmarshal(data, &f.responses[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fakeRecognizer) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.responses) == 0 {
+		return structs.Recognition{}, ErrNoMatch
+	}
+
+	response := f.responses[f.next%len(f.responses)]
+	f.next++
+
+	rec, err := response.recognition()
+	rec.Backend = "fake"
+	return rec, err
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"shazammini/src/audio"
+	"shazammini/src/history"
+	"shazammini/src/structs"
+	"shazammini/src/utils"
+	"time"
+)
+
+// HistoryFile is the database of every recognition
+func (cfg Config) HistoryFile() string {
+	if cfg.History.File == "" {
+		return "history.db"
+	}
+	return cfg.History.File
+}
+
+// outcome sums up what adding the recognition to Spotify returned
+func outcome(err error) (string, string) {
+	switch {
+	case err == nil:
+		return history.Added, ""
+	case errors.Is(err, ErrAlreadyInPlaylist):
+		return history.Duplicate, ""
+	case errors.Is(err, ErrNotConfirmed):
+		return history.Skipped, ""
+	}
+	return history.Failed, displayError(err)
+}
+
+// remember adds the recognition to the history and notes its entry in rec,
+// err is what adding it to Spotify returned
+func remember(store *history.Store, rec *structs.Recognition, err error, clip string) {
+	result, reason := outcome(err)
+	id, err := store.Add(history.Entry{
+		Recognition:  *rec,
+		RecordedAt:   recordedAt(*rec),
+		RecognizedAt: time.Now(),
+		Clip:         clip,
+		Outcome:      result,
+		Error:        reason,
+	})
+	if err != nil {
+		log.Println("Could not add recognition to the history:", err)
+		return
+	}
+	rec.ID = id
+}
+
+// reportDelivery records in the history where a recognition stands with a sink
+func reportDelivery(store *history.Store) deliveryReport {
+	return func(sink string, rec structs.Recognition, status string, err error) {
+		// queued before the history existed
+		if rec.ID == 0 {
+			return
+		}
+		d := history.Delivery{Status: status, At: time.Now()}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if err := store.SetDelivery(rec.ID, sink, d); err != nil {
+			log.Printf("Could not record delivery to %s in the history: %v", sink, err)
+		}
+	}
+}
+
+// keepClip saves the recording in the clips directory when one is set and
+// returns where, the recording file is overwritten by the next recording
+func (cfg Config) keepClip(clip audio.Clip, recordedAt time.Time) string {
+	if cfg.History.Clips == "" {
+		return ""
+	}
+	if err := os.MkdirAll(cfg.History.Clips, 0755); err != nil {
+		log.Println("Could not keep recording:", err)
+		return ""
+	}
+	path := filepath.Join(cfg.History.Clips, fmt.Sprintf("%d.wav", recordedAt.UnixMilli()))
+	if err := utils.WriteFileAtomic(path, clip.EncodeWAV(), 0644); err != nil {
+		log.Println("Could not keep recording:", err)
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"shazammini/src/lastfm"
+	"shazammini/src/structs"
+	"time"
+)
+
+// lastfmSink scrobbles every recognized track
+type lastfmSink struct {
+	client *lastfm.Client
+}
+
+// LastfmClient is the Last.fm client configured in creds.toml, without session key
+func (cfg Config) LastfmClient() *lastfm.Client {
+	return &lastfm.Client{
+		URL:    endpoint(cfg.Endpoints.Lastfm, "https://ws.audioscrobbler.com/2.0") + "/",
+		APIKey: cfg.Lastfm.APIKey,
+		Secret: cfg.Lastfm.Secret,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LastfmSessionFile is where the session key obtained with the lastfm auth command is kept
+func (cfg Config) LastfmSessionFile() string {
+	if cfg.Lastfm.SessionFile == "" {
+		return "lastfm_session.toml"
+	}
+	return cfg.Lastfm.SessionFile
+}
+
+// newLastfmSink returns nil when Last.fm is not set up
+func newLastfmSink(cfg Config) (*lastfmSink, error) {
+	if cfg.Lastfm.APIKey == "" {
+		return nil, nil
+	}
+	key, err := lastfm.LoadSession(cfg.LastfmSessionFile())
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, errors.New("no Last.fm session, run the lastfm auth command")
+	}
+	client := cfg.LastfmClient()
+	client.SessionKey = key
+	return &lastfmSink{client: client}, nil
+}
+
+func (l *lastfmSink) Name() string {
+	return "lastfm"
+}
+
+func (l *lastfmSink) Send(rec structs.Recognition) error {
+	err := l.client.Scrobble(lastfm.Scrobble{
+		Artist: mainArtist(&rec.Track),
+		Track:  rec.Track.Title,
+		At:     recordedAt(rec),
+	})
+
+	var apiErr *lastfm.Error
+	if errors.As(err, &apiErr) && !apiErr.Temporary() {
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"shazammini/src/structs"
+	"strings"
+	"time"
+)
+
+// listenBrainzSink submits every recognized track as a listen
+type listenBrainzSink struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                     `json:"listened_at"`
+	TrackMetadata listenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+type listenBrainzTrackMetadata struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	AdditionalInfo map[string]interface{} `json:"additional_info,omitempty"`
+}
+
+// newListenBrainzSink returns nil when ListenBrainz is not set up
+func newListenBrainzSink(cfg Config) *listenBrainzSink {
+	if cfg.ListenBrainz.Token == "" {
+		return nil
+	}
+	return &listenBrainzSink{
+		url:   endpoint(cfg.Endpoints.ListenBrainz, "https://api.listenbrainz.org") + "/1/submit-listens",
+		token: cfg.ListenBrainz.Token,
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (l *listenBrainzSink) Name() string {
+	return "listenbrainz"
+}
+
+func (l *listenBrainzSink) Send(rec structs.Recognition) error {
+	info := map[string]interface{}{
+		"submission_client": "ShazPi",
+		"media_player":      "ShazPi",
+	}
+	if rec.Track.Isrc != "" {
+		info["isrc"] = rec.Track.Isrc
+	}
+	if rec.Track.Genre.Primary != "" {
+		info["tags"] = []string{rec.Track.Genre.Primary}
+	}
+
+	data, err := json.Marshal(listenBrainzSubmission{
+		ListenType: "single",
+		Payload: []listenBrainzListen{{
+			ListenedAt: recordedAt(rec).Unix(),
+			TrackMetadata: listenBrainzTrackMetadata{
+				ArtistName:     rec.Track.Subtitle,
+				TrackName:      rec.Track.Title,
+				AdditionalInfo: info,
+			},
+		}},
+	})
+	if err != nil {
+		return permanent(err)
+	}
+
+	req, err := http.NewRequest("POST", l.url, bytes.NewReader(data))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Authorization", "Token "+l.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := l.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = fmt.Errorf("ListenBrainz answered %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	// a listen refused now is refused forever, unless the server was
+	// overloaded or the token is wrong, listens wait for it to be fixed
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusTooManyRequests, res.StatusCode >= 500:
+		return err
+	}
+	return permanent(err)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+func TestListenBrainzSend(t *testing.T) {
+	recorded := time.Date(2024, 5, 17, 21, 30, 0, 0, time.UTC)
+	rec := structs.Recognition{
+		Timestamp: recorded.UnixMilli(),
+		Track: structs.Track{
+			Title:    "One More Time",
+			Subtitle: "Daft Punk",
+			Isrc:     "GBDUW0000053",
+			Genre:    structs.Genre{Primary: "Dance"},
+		},
+	}
+
+	var got listenBrainzSubmission
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/1/submit-listens" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Token secret" {
+			t.Errorf("got Authorization %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{}
+	cfg.ListenBrainz.Token = "secret"
+	cfg.Endpoints.ListenBrainz = srv.URL
+	sink := newListenBrainzSink(cfg)
+	if err := sink.Send(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ListenType != "single" || len(got.Payload) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	listen := got.Payload[0]
+	if listen.ListenedAt != recorded.Unix() {
+		t.Errorf("got listened_at %d, want %d", listen.ListenedAt, recorded.Unix())
+	}
+	if listen.TrackMetadata.TrackName != "One More Time" || listen.TrackMetadata.ArtistName != "Daft Punk" {
+		t.Errorf("got %+v", listen.TrackMetadata)
+	}
+	if isrc := listen.TrackMetadata.AdditionalInfo["isrc"]; isrc != "GBDUW0000053" {
+		t.Errorf("got isrc %v", isrc)
+	}
+}
+
+func TestListenBrainzErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"nope"}`, tt.status)
+			}))
+			defer srv.Close()
+
+			sink := &listenBrainzSink{url: srv.URL, token: "secret", http: srv.Client()}
+			err := sink.Send(structs.Recognition{Track: structs.Track{Title: "Song", Subtitle: "Band"}})
+			if err == nil {
+				t.Fatal("refused listen accepted")
+			}
+			if got := errors.As(err, &permanentError{}); got != tt.permanent {
+				t.Errorf("got permanent %v, want %v: %v", got, tt.permanent, err)
+			}
+		})
+	}
+
+	// the server is down
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	sink := &listenBrainzSink{url: srv.URL, token: "secret", http: &http.Client{Timeout: time.Second}}
+	err := sink.Send(structs.Recognition{})
+	if err == nil || errors.As(err, &permanentError{}) {
+		t.Errorf("got %v, want a retryable error", err)
+	}
+}
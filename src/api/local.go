@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"shazammini/src/audio"
+	"shazammini/src/fingerprint"
+	"shazammini/src/structs"
+)
+
+// localRecognizer matches recordings against the fingerprints of our own
+// library, it needs neither network nor API quota
+type localRecognizer struct {
+	db       *fingerprint.Database
+	minScore int
+}
+
+func newLocalRecognizer(index string, minScore int) (*localRecognizer, error) {
+	db, err := fingerprint.Load(index)
+	if err != nil {
+		return nil, err
+	}
+	return &localRecognizer{db: db, minScore: minScore}, nil
+}
+
+func (l *localRecognizer) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	match, ok := l.db.Match(clip, l.minScore)
+	if !ok {
+		return structs.Recognition{}, ErrNoMatch
+	}
+
+	key := fmt.Sprintf("local:%d", match.Song.ID)
+	return structs.Recognition{
+		Track: structs.Track{
+			Key:      key,
+			Title:    match.Song.Title,
+			Subtitle: match.Song.Artist,
+			Artists:  []structs.Artist{{Name: match.Song.Artist}},
+		},
+		Matches:    []structs.Match{{ID: key, Offset: match.Offset}},
+		Confidence: match.Confidence,
+		Backend:    "local",
+	}, nil
+}
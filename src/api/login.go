@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const loginScope = "user-read-private playlist-modify-private playlist-read-private playlist-read-collaborative playlist-modify-public user-library-modify"
+
+// ErrLoginTimeout is returned when nobody completed the Spotify login in time
+var ErrLoginTimeout = errors.New("Spotify login timed out")
+
+type loginConfig struct {
+	authorizeURL string
+	// redirectURI must be registered in the Spotify app and reach the callback server from the phone
+	redirectURI string
+	// addr is where the callback server listens
+	addr    string
+	timeout time.Duration
+}
+
+// pageURL is the short address shown as a QR code, it redirects to Spotify
+func (c loginConfig) pageURL() string {
+	u, err := url.Parse(c.redirectURI)
+	if err != nil {
+		return c.redirectURI
+	}
+	u.Path = "/login"
+	u.RawQuery = ""
+	return u.String()
+}
+
+func generateRandomString(length int) string {
+	// Available characters for random string generation, all allowed in a PKCE verifier
+	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	max := big.NewInt(int64(len(charset)))
+
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b)
+}
+
+// codeChallenge derives the PKCE challenge sent to Spotify from the verifier kept on the device
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Login runs the authorization code flow with PKCE, so that no client secret
+// is needed. The display shows a QR code pointing to the login page of the
+// callback server, which redirects the phone that scanned it to Spotify
+func (s *spotifyAPI) Login() error {
+	state := generateRandomString(16)
+	verifier := generateRandomString(64)
+
+	authorizeURL := s.login.authorizeURL + "?" +
+		url.Values{
+			"response_type":         {"code"},
+			"client_id":             {s.clientID},
+			"scope":                 {loginScope},
+			"redirect_uri":          {s.login.redirectURI},
+			"state":                 {state},
+			"code_challenge_method": {"S256"},
+			"code_challenge":        {codeChallenge(verifier)},
+		}.Encode()
+
+	done := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, authorizeURL, http.StatusFound)
+	})
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		queryParams := r.URL.Query()
+		if queryParams.Get("state") != state {
+			log.Printf("States are different. This is a security risk... %s vs %s", queryParams.Get("state"), state)
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+		if reason := queryParams.Get("error"); reason != "" {
+			fmt.Fprintln(w, "Spotify login refused:", reason)
+			done <- fmt.Errorf("Spotify login refused: %s", reason)
+			return
+		}
+
+		err := s.tokens.AuthorizePKCE(queryParams.Get("code"), s.login.redirectURI, verifier)
+		if err != nil {
+			http.Error(w, "Could not log in to Spotify", http.StatusBadGateway)
+		} else {
+			fmt.Fprintln(w, "ShazPi is now logged in to Spotify, you can close this page.")
+		}
+		done <- err
+	})
+
+	server := &http.Server{Addr: s.login.addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			done <- err
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	log.Println("Waiting for Spotify login on", s.login.pageURL())
+	s.notify.LoginLink(s.login.pageURL())
+	s.commChannels.DisplayLogin <- s.login.pageURL()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(s.login.timeout):
+		err = ErrLoginTimeout
+	}
+
+	if err != nil {
+		s.commChannels.DisplayMessage <- "Spotify login failed"
+		return err
+	}
+	s.commChannels.DisplayMessage <- "Logged in to Spotify"
+	return nil
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"shazammini/src/structs"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// minMatchScore is the score a Spotify track needs to be taken for the recognized one
+const minMatchScore = 0.7
+
+// NoConfidentMatchError is returned when no Spotify track is close enough to the recognized one
+type NoConfidentMatchError struct {
+	Title  string
+	Artist string
+	// Best is the closest candidate, empty when the search found nothing
+	Best  string
+	Score float64
+}
+
+func (e *NoConfidentMatchError) Error() string {
+	if e.Best == "" {
+		return fmt.Sprintf("no Spotify track found for %s by %s", e.Title, e.Artist)
+	}
+	return fmt.Sprintf("no confident Spotify match for %s by %s, best was %s (%.2f)", e.Title, e.Artist, e.Best, e.Score)
+}
+
+// candidate is a Spotify track that may be the recognized one
+type candidate struct {
+	Items
+	score float64
+	// exact is set when the ISRC is the one of the recognized recording
+	exact bool
+}
+
+// FindTrack returns the URI of the Spotify track matching the recognized one.
+// position is how far into the song the recording was made, a shorter track
+// cannot be the one that was heard
+func (s *spotifyAPI) FindTrack(track *structs.Track, position time.Duration) (string, error) {
+	candidates, err := s.Candidates(track, position, 1)
+	if err != nil {
+		return "", err
+	}
+
+	e := &NoConfidentMatchError{Title: track.Title, Artist: mainArtist(track)}
+	if len(candidates) == 0 {
+		return "", e
+	}
+	best := candidates[0]
+	if !best.exact && best.score < minMatchScore {
+		e.Best = best.describe()
+		e.Score = best.score
+		return "", e
+	}
+	return best.Uri, nil
+}
+
+// Candidates returns at least want Spotify tracks that may be the recognized
+// one when Spotify finds that many, best first. The ISRC identifies the
+// recording exactly, the title and artist are only searched when the tracks
+// with that ISRC are not enough
+func (s *spotifyAPI) Candidates(track *structs.Track, position time.Duration, want int) ([]candidate, error) {
+	artist := mainArtist(track)
+	var found []candidate
+
+	if track.Isrc != "" {
+		items, err := s.search("isrc:" + track.Isrc)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if strings.EqualFold(item.ExternalIDs.ISRC, track.Isrc) {
+				// several releases share the recording, prefer the one named like it
+				found = append(found, candidate{Items: item, score: matchScore(track, artist, position, item), exact: true})
+			}
+		}
+		sortCandidates(found)
+		if len(found) >= want {
+			return found, nil
+		}
+	}
+
+	items, err := s.search(fmt.Sprintf("track:%s artist:%s", cleanTitle(track.Title), artist))
+	if err != nil {
+		return nil, err
+	}
+	var others []candidate
+	for _, item := range items {
+		known := false
+		for _, c := range found {
+			known = known || c.Uri == item.Uri
+		}
+		if !known {
+			others = append(others, candidate{Items: item, score: matchScore(track, artist, position, item)})
+		}
+	}
+	sortCandidates(others)
+	return append(found, others...), nil
+}
+
+func sortCandidates(candidates []candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+}
+
+// search returns the tracks Spotify finds for the query
+func (s *spotifyAPI) search(query string) ([]Items, error) {
+	token, err := s.tokens.SearchToken()
+	if err != nil {
+		return nil, err
+	}
+
+	u := s.search_url + "?" + url.Values{"q": {query}, "type": {"track"}, "limit": {"10"}}.Encode()
+	var spotifyReponse SotifyResponse
+	if err := s.client.getJSON(token, u, &spotifyReponse); err != nil {
+		return nil, fmt.Errorf("could not search Spotify for %q: %w", query, err)
+	}
+	return spotifyReponse.Tracks.Items, nil
+}
+
+// matchScore tells how likely the candidate is the recognized track, between 0 and 1
+func matchScore(track *structs.Track, artist string, position time.Duration, candidate Items) float64 {
+	title := similarity(normalize(cleanTitle(track.Title)), normalize(cleanTitle(candidate.Name)))
+
+	artistScore := 0.0
+	heard := normalize(track.Subtitle + " " + artist)
+	for _, a := range candidate.Artists {
+		name := normalize(a.Name)
+		score := similarity(normalize(artist), name)
+		// the subtitle lists every artist, "Daft Punk Featuring Pharrell Williams"
+		if name != "" && strings.Contains(" "+heard+" ", " "+name+" ") {
+			score = 1
+		}
+		if score > artistScore {
+			artistScore = score
+		}
+	}
+
+	score := 0.6*title + 0.4*artistScore
+	if candidate.DurationMs > 0 && position > time.Duration(candidate.DurationMs)*time.Millisecond {
+		score /= 2
+	}
+	return score
+}
+
+// mainArtist is the first artist credited for the track
+func mainArtist(track *structs.Track) string {
+	for _, a := range track.Artists {
+		if a.Name != "" {
+			return a.Name
+		}
+	}
+	artist := track.Subtitle
+	lower := strings.ToLower(artist)
+	for _, sep := range []string{" featuring ", " feat. ", " feat ", " ft. ", " & ", " x ", ", "} {
+		if i := strings.Index(lower, sep); i > 0 {
+			artist = artist[:i]
+			lower = lower[:i]
+		}
+	}
+	return strings.TrimSpace(artist)
+}
+
+// cleanTitle drops what releases add to a title, "Song (Radio Edit) - Remastered 2011" becomes "Song"
+func cleanTitle(title string) string {
+	if i := strings.Index(title, " - "); i > 0 {
+		title = title[:i]
+	}
+	for _, pair := range []string{"()", "[]"} {
+		for {
+			open := strings.IndexByte(title, pair[0])
+			if open < 0 {
+				break
+			}
+			end := strings.IndexByte(title[open:], pair[1])
+			if end < 0 {
+				title = title[:open]
+				break
+			}
+			title = title[:open] + title[open+end+1:]
+		}
+	}
+	return strings.TrimSpace(title)
+}
+
+// normalize keeps lower case letters and digits separated by single spaces
+func normalize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else if r != '\'' {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// similarity is 1 minus the edit distance between a and b relative to the longest
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+func spotifyItem(uri, name, artist, isrc string) Items {
+	item := Items{Type: "track", Uri: uri, Name: name}
+	item.ExternalIDs.ISRC = isrc
+	item.Artists = append(item.Artists, struct {
+		Name string `json:"name"`
+	}{Name: artist})
+	return item
+}
+
+// searchStandIn answers each Spotify search with the tracks listed for its
+// query and records the queries in the order they were made
+func searchStandIn(t *testing.T, results map[string][]Items) (*spotifyAPI, *[]string) {
+	var queries []string
+	s := testSpotify(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/search" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer app" {
+			t.Errorf("searched with %q", got)
+		}
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		var found SotifyResponse
+		found.Tracks.Items = results[q]
+		json.NewEncoder(w).Encode(found)
+	}))
+	s.tokens.clientSecret = "secret"
+	return s, &queries
+}
+
+func getLucky() structs.Track {
+	return structs.Track{
+		Title:    "Get Lucky (Radio Edit)",
+		Subtitle: "Daft Punk Featuring Pharrell Williams",
+		Isrc:     "USQX91300108",
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Song", "Song"},
+		{"Song (Radio Edit) - Remastered 2011", "Song"},
+		{"Song [Live] (feat. Someone)", "Song"},
+		{"Song (Live", "Song"},
+		{"Song - 2011 Remaster", "Song"},
+		{"Don't Stop Me Now", "Don't Stop Me Now"},
+	}
+	for _, tt := range tests {
+		if got := cleanTitle(tt.title); got != tt.want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMainArtist(t *testing.T) {
+	tests := []struct {
+		name  string
+		track structs.Track
+		want  string
+	}{
+		{"credited artist", structs.Track{Subtitle: "Someone Else", Artists: []structs.Artist{{}, {Name: "Daft Punk"}}}, "Daft Punk"},
+		{"single", structs.Track{Subtitle: "Sia"}, "Sia"},
+		{"featuring", structs.Track{Subtitle: "Daft Punk Featuring Pharrell Williams"}, "Daft Punk"},
+		{"feat.", structs.Track{Subtitle: "Eminem feat. Rihanna"}, "Eminem"},
+		{"ft.", structs.Track{Subtitle: "Drake ft. Rihanna"}, "Drake"},
+		{"comma", structs.Track{Subtitle: "Calvin Harris, Dua Lipa"}, "Calvin Harris"},
+		{"ampersand", structs.Track{Subtitle: "David Guetta & Sia"}, "David Guetta"},
+		{"x", structs.Track{Subtitle: "Marshmello x Bastille"}, "Marshmello"},
+		{"several", structs.Track{Subtitle: "A, B & C feat. D"}, "A"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mainArtist(&tt.track); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"Don't Stop Me Now!", "dont stop me now"},
+		{"  AC/DC ", "ac dc"},
+		{"Beyoncé", "beyoncé"},
+		{"Blink-182", "blink 182"},
+		{"--", ""},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.s); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 0},
+		{"song", "song", 1},
+		{"song", "", 0},
+		{"abc", "xyz", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"beyoncé", "beyonce", 1 - 1.0/7},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %f, want %f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	track := getLucky()
+	artist := mainArtist(&track)
+	short := spotifyItem("spotify:track:short", "Get Lucky", "Daft Punk", "")
+	short.DurationMs = 60000
+
+	tests := []struct {
+		name      string
+		candidate Items
+		position  time.Duration
+		want      float64
+		confident bool
+	}{
+		{"same track", spotifyItem("", "Get Lucky", "Daft Punk", ""), 0, 1, true},
+		{"other release", spotifyItem("", "Get Lucky - Remastered", "Daft Punk", ""), 0, 1, true},
+		{"featured artist", spotifyItem("", "Get Lucky", "Pharrell Williams", ""), 0, 1, true},
+		{"shorter than the position", short, 2 * time.Minute, 0.5, false},
+		{"long enough", short, 30 * time.Second, 1, true},
+		{"same artist", spotifyItem("", "Lose Yourself", "Daft Punk", ""), 0, -1, false},
+		{"other song", spotifyItem("", "Lose Yourself", "Eminem", ""), 0, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchScore(&track, artist, tt.position, tt.candidate)
+			if tt.want >= 0 && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %f, want %f", got, tt.want)
+			}
+			if confident := got >= minMatchScore; confident != tt.confident {
+				t.Errorf("score %f confident %v, want %v", got, confident, tt.confident)
+			}
+		})
+	}
+}
+
+func TestFindTrack(t *testing.T) {
+	const isrcQuery = "isrc:USQX91300108"
+	const textQuery = "track:Get Lucky artist:Daft Punk"
+	tests := []struct {
+		name        string
+		results     map[string][]Items
+		wantURI     string
+		wantQueries []string
+		wantBest    string // set when no confident match is expected
+		wantMissing bool   // the search found nothing
+	}{
+		{
+			name: "isrc first",
+			results: map[string][]Items{
+				isrcQuery: {
+					spotifyItem("spotify:track:other", "Get Lucky", "Daft Punk", "OTHER0000000"),
+					spotifyItem("spotify:track:compilation", "Get Lucky", "Various Artists", "USQX91300108"),
+					spotifyItem("spotify:track:album", "Get Lucky", "Daft Punk", "USQX91300108"),
+				},
+			},
+			wantURI:     "spotify:track:album",
+			wantQueries: []string{isrcQuery},
+		},
+		{
+			name: "exact despite the score",
+			results: map[string][]Items{
+				isrcQuery: {spotifyItem("spotify:track:renamed", "Something Else", "Someone", "usqx91300108")},
+			},
+			wantURI:     "spotify:track:renamed",
+			wantQueries: []string{isrcQuery},
+		},
+		{
+			name: "title and artist without isrc match",
+			results: map[string][]Items{
+				textQuery: {
+					spotifyItem("spotify:track:cover", "Get Lucky", "Cover Band", ""),
+					spotifyItem("spotify:track:original", "Get Lucky", "Daft Punk", ""),
+				},
+			},
+			wantURI:     "spotify:track:original",
+			wantQueries: []string{isrcQuery, textQuery},
+		},
+		{
+			name: "no confident match",
+			results: map[string][]Items{
+				textQuery: {spotifyItem("spotify:track:wrong", "Lose Yourself", "Eminem", "")},
+			},
+			wantQueries: []string{isrcQuery, textQuery},
+			wantBest:    "Lose Yourself by Eminem",
+		},
+		{
+			name:        "nothing found",
+			wantQueries: []string{isrcQuery, textQuery},
+			wantMissing: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, queries := searchStandIn(t, tt.results)
+			track := getLucky()
+			uri, err := s.FindTrack(&track, 0)
+
+			if !reflect.DeepEqual(*queries, tt.wantQueries) {
+				t.Errorf("searched %q, want %q", *queries, tt.wantQueries)
+			}
+			if tt.wantBest == "" && !tt.wantMissing {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if uri != tt.wantURI {
+					t.Errorf("got %s, want %s", uri, tt.wantURI)
+				}
+				return
+			}
+
+			var noMatch *NoConfidentMatchError
+			if !errors.As(err, &noMatch) {
+				t.Fatalf("got %q, %v, want a NoConfidentMatchError", uri, err)
+			}
+			if noMatch.Title != track.Title || noMatch.Artist != "Daft Punk" {
+				t.Errorf("error names %s by %s", noMatch.Title, noMatch.Artist)
+			}
+			if noMatch.Best != tt.wantBest {
+				t.Errorf("best was %q, want %q", noMatch.Best, tt.wantBest)
+			}
+			if !tt.wantMissing && noMatch.Score >= minMatchScore {
+				t.Errorf("rejected a candidate scored %f", noMatch.Score)
+			}
+		})
+	}
+}
+
+func TestCandidatesAfterIsrc(t *testing.T) {
+	s, _ := searchStandIn(t, map[string][]Items{
+		"isrc:USQX91300108": {spotifyItem("spotify:track:album", "Get Lucky", "Daft Punk", "USQX91300108")},
+		"track:Get Lucky artist:Daft Punk": {
+			spotifyItem("spotify:track:cover", "Get Lucky", "Cover Band", ""),
+			spotifyItem("spotify:track:album", "Get Lucky", "Daft Punk", "USQX91300108"),
+			spotifyItem("spotify:track:edit", "Get Lucky - Edit", "Daft Punk", ""),
+		},
+	})
+	track := getLucky()
+	candidates, err := s.Candidates(&track, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uris []string
+	for _, c := range candidates {
+		uris = append(uris, c.Uri)
+	}
+	want := []string{"spotify:track:album", "spotify:track:edit", "spotify:track:cover"}
+	if !reflect.DeepEqual(uris, want) {
+		t.Errorf("got %v, want %v", uris, want)
+	}
+	if !candidates[0].exact || candidates[1].exact {
+		t.Error("only the track with the ISRC should be exact")
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"shazammini/src/utils"
+	"strings"
+	"time"
+)
+
+// monthlyPlaylist can be used instead of a playlist id, in playlist_id or in
+// routing rules, to add tracks to the playlist of the month they were recorded in
+const monthlyPlaylist = "monthly"
+
+// monthlyPlaylists finds or creates the playlist of each month on the
+// account logged in, and remembers their ids by name
+type monthlyPlaylists struct {
+	client *spotifyClient
+	path   string
+	apiURL string
+	// name of the playlists, {month} is replaced by the year and month
+	name string
+	ids  map[string]string
+}
+
+func newMonthlyPlaylists(client *spotifyClient, path, apiURL, name string) *monthlyPlaylists {
+	m := &monthlyPlaylists{client: client, path: path, apiURL: apiURL, name: name, ids: map[string]string{}}
+
+	// a missing mapping is rebuilt from the playlists of the account
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &m.ids)
+	}
+	return m
+}
+
+// ID returns the id of the playlist of the month of t
+func (m *monthlyPlaylists) ID(token string, t time.Time) (string, error) {
+	name := strings.ReplaceAll(m.name, "{month}", t.Format("2006-01"))
+	if id, ok := m.ids[name]; ok {
+		return id, nil
+	}
+
+	var me struct {
+		ID string `json:"id"`
+	}
+	if err := m.client.getJSON(token, m.apiURL+"/me", &me); err != nil {
+		return "", fmt.Errorf("could not get Spotify user: %w", err)
+	}
+
+	id, err := m.find(token, me.ID, name)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		id, err = m.create(token, me.ID, name, t)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	m.ids[name] = id
+	if err := m.save(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// find looks for a playlist with that name owned by the user
+func (m *monthlyPlaylists) find(token, userID, name string) (string, error) {
+	next := m.apiURL + "/me/playlists?limit=50"
+	for next != "" {
+		var page struct {
+			Next  string `json:"next"`
+			Items []struct {
+				ID    string `json:"id"`
+				Name  string `json:"name"`
+				Owner struct {
+					ID string `json:"id"`
+				} `json:"owner"`
+			} `json:"items"`
+		}
+		if err := m.client.getJSON(token, next, &page); err != nil {
+			return "", fmt.Errorf("could not list Spotify playlists: %w", err)
+		}
+		for _, p := range page.Items {
+			if p.Name == name && p.Owner.ID == userID {
+				return p.ID, nil
+			}
+		}
+		next = page.Next
+	}
+	return "", nil
+}
+
+func (m *monthlyPlaylists) create(token, userID, name string, t time.Time) (string, error) {
+	playlist := struct {
+		Name        string `json:"name"`
+		Public      bool   `json:"public"`
+		Description string `json:"description"`
+	}{
+		Name:        name,
+		Public:      false,
+		Description: "Songs recognized by ShazPi in " + t.Format("January 2006"),
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	u := m.apiURL + "/users/" + url.PathEscape(userID) + "/playlists"
+	if err := m.client.callJSON("POST", token, u, playlist, &created); err != nil {
+		return "", fmt.Errorf("could not create playlist %s: %w", name, err)
+	}
+	return created.ID, nil
+}
+
+func (m *monthlyPlaylists) save() error {
+	data, err := json.MarshalIndent(m.ids, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(m.path, data, 0644)
+}
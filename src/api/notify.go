@@ -0,0 +1,222 @@
+package api
+
+import (
+	htmltemplate "html/template"
+	"log"
+	"shazammini/src/mail"
+	"shazammini/src/structs"
+	"strings"
+	"text/template"
+)
+
+// notice is an email template, the subject and the plain text body are text
+// templates, the HTML body escapes what it is given
+type notice struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+func newNotice(subject, text, html string) notice {
+	return notice{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		text:    template.Must(template.New("text").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New("html").Parse(html)),
+	}
+}
+
+var (
+	loginNotice = newNotice(
+		`Log in to Spotify on {{.Device}}`,
+		`{{.Device}} needs you to log in to Spotify to add songs to your playlist.
+
+Open this page on a device connected to the same network:
+{{.URL}}
+`,
+		`<p>{{.Device}} needs you to log in to Spotify to add songs to your playlist.</p>
+<p>Open this page on a device connected to the same network:<br><a href="{{.URL}}">{{.URL}}</a></p>
+`)
+
+	unmatchedNotice = newNotice(
+		`Not found on Spotify: {{.Title}} by {{.Artist}}`,
+		`{{.Device}} recognized {{.Title}} by {{.Artist}} on {{.Time}} but could not find it on Spotify.
+{{if .ISRC}}
+ISRC: {{.ISRC}}{{end}}{{if .ShazamURL}}
+Shazam: {{.ShazamURL}}{{end}}
+`,
+		`<p>{{.Device}} recognized <b>{{.Title}}</b> by {{.Artist}} on {{.Time}} but could not find it on Spotify.</p>
+{{if .CoverArt}}<p><img src="{{.CoverArt}}" alt="" width="200"></p>
+{{end}}<ul>{{if .ISRC}}<li>ISRC: {{.ISRC}}</li>{{end}}{{if .ShazamURL}}<li><a href="{{.ShazamURL}}">Open in Shazam</a></li>{{end}}</ul>
+`)
+
+	recognizedNotice = newNotice(
+		`{{.Title}} by {{.Artist}}`,
+		`{{.Device}} recognized {{.Title}} by {{.Artist}} on {{.Time}}.
+{{if .SpotifyURL}}
+Spotify: {{.SpotifyURL}}{{end}}{{if .ShazamURL}}
+Shazam: {{.ShazamURL}}{{end}}
+`,
+		`<p>{{.Device}} recognized <b>{{.Title}}</b> by {{.Artist}} on {{.Time}}.</p>
+{{if .CoverArt}}<p><img src="{{.CoverArt}}" alt="" width="200"></p>
+{{end}}<ul>{{if .SpotifyURL}}<li><a href="{{.SpotifyURL}}">Open in Spotify</a></li>{{end}}{{if .ShazamURL}}<li><a href="{{.ShazamURL}}">Open in Shazam</a></li>{{end}}</ul>
+`)
+
+	digestNotice = newNotice(
+		`{{.Device}} on {{.Day}}: {{.Count}} songs, {{.Added}} added to Spotify`,
+		`{{.Device}} recognized {{.Count}} songs, {{.Added}} were added to Spotify{{if .Failed}} and {{.Failed}} failed{{end}}.
+{{range .Tracks}}
+{{.Time}}  {{.Title}} by {{.Artist}}, {{.Outcome}}{{end}}
+`,
+		`<p>{{.Device}} recognized {{.Count}} songs, {{.Added}} were added to Spotify{{if .Failed}} and {{.Failed}} failed{{end}}.</p>
+<table cellpadding="4">
+{{range .Tracks}}<tr>
+<td>{{if .CoverArt}}<img src="{{.CoverArt}}" alt="" width="48" height="48">{{end}}</td>
+<td>{{.Time}}</td>
+<td><b>{{.Title}}</b><br>{{.Artist}}</td>
+<td{{if not .Added}} style="color:#888"{{end}}>{{.Outcome}}</td>
+</tr>
+{{end}}</table>
+`)
+)
+
+// noticeData is what the templates can show
+type noticeData struct {
+	Device     string
+	URL        string
+	Title      string
+	Artist     string
+	Time       string
+	ISRC       string
+	CoverArt   string
+	ShazamURL  string
+	SpotifyURL string
+	// daily digest
+	Day    string
+	Count  int
+	Added  int
+	Failed int
+	Tracks []digestTrack
+}
+
+// notifier emails the login link, the tracks not found on Spotify and, when
+// enabled, every recognition. A nil notifier sends nothing
+type notifier struct {
+	client *mail.Client
+	to     []string
+	device string
+}
+
+// emailSink sends a notice for every recognition through the sink queue, so
+// that notices wait for the mail server like the other sinks
+type emailSink struct {
+	notify *notifier
+}
+
+// newNotifier returns nil when email is not set up
+func newNotifier(cfg Config) *notifier {
+	if cfg.Email.Host == "" || len(cfg.Email.To) == 0 {
+		return nil
+	}
+	port := cfg.Email.Port
+	if port == 0 {
+		port = 587
+	}
+	return &notifier{
+		client: &mail.Client{
+			Host:     cfg.Email.Host,
+			Port:     port,
+			Username: cfg.Email.Username,
+			Password: cfg.Email.Password,
+			From:     cfg.Email.From,
+			Security: cfg.Email.Security,
+		},
+		to:     cfg.Email.To,
+		device: cfg.DeviceName(),
+	}
+}
+
+func (n *notifier) send(tmpl notice, data noticeData) error {
+	m, err := n.message(tmpl, data)
+	if err != nil {
+		return err
+	}
+	return n.client.Send(m)
+}
+
+// message fills the template in
+func (n *notifier) message(tmpl notice, data noticeData) (mail.Message, error) {
+	data.Device = n.device
+	var subject, text, html strings.Builder
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{
+		To:      n.to,
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// background sends without holding up the caller, failures are only logged
+func (n *notifier) background(what string, tmpl notice, data noticeData) {
+	if n == nil {
+		return
+	}
+	go func() {
+		if err := n.send(tmpl, data); err != nil {
+			log.Printf("Could not email %s: %v", what, err)
+		}
+	}()
+}
+
+// LoginLink emails the page to open to log in to Spotify
+func (n *notifier) LoginLink(url string) {
+	n.background("login link", loginNotice, noticeData{URL: url})
+}
+
+// Unmatched emails a recognized track that is not on Spotify, to add it by hand
+func (n *notifier) Unmatched(rec structs.Recognition) {
+	n.background("unmatched track", unmatchedNotice, recognitionData(rec))
+}
+
+func recognitionData(rec structs.Recognition) noticeData {
+	data := noticeData{
+		Title:     rec.Track.Title,
+		Artist:    rec.Track.Subtitle,
+		Time:      recordedAt(rec).Format("Monday 2 January 15:04"),
+		ISRC:      rec.Track.Isrc,
+		CoverArt:  rec.Track.Image.CoverArt,
+		ShazamURL: rec.Track.Url,
+	}
+	if id := strings.TrimPrefix(rec.SpotifyURI, "spotify:track:"); id != rec.SpotifyURI {
+		data.SpotifyURL = "https://open.spotify.com/track/" + id
+	}
+	return data
+}
+
+// newEmailSink returns nil unless a notice is wanted for every recognition
+func newEmailSink(cfg Config, n *notifier) *emailSink {
+	if n == nil || !cfg.Email.Recognitions {
+		return nil
+	}
+	return &emailSink{notify: n}
+}
+
+func (e *emailSink) Name() string {
+	return "email"
+}
+
+func (e *emailSink) Send(rec structs.Recognition) error {
+	err := e.notify.send(recognizedNotice, recognitionData(rec))
+	if mail.Permanent(err) {
+		return permanent(err)
+	}
+	return err
+}
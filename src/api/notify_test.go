@@ -0,0 +1,79 @@
+package api
+
+import (
+	"shazammini/src/structs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginNotice(t *testing.T) {
+	n := &notifier{to: []string{"me@example.com"}, device: "kitchen"}
+	m, err := n.message(loginNotice, noticeData{URL: "http://192.168.1.20:8888/login?a=1&b=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Log in to Spotify on kitchen" {
+		t.Errorf("got subject %q", m.Subject)
+	}
+	if len(m.To) != 1 || m.To[0] != "me@example.com" {
+		t.Errorf("got To %v", m.To)
+	}
+	if !strings.Contains(m.Text, "kitchen needs you") || !strings.Contains(m.Text, "\nhttp://192.168.1.20:8888/login?a=1&b=2\n") {
+		t.Errorf("got text %q", m.Text)
+	}
+	if !strings.Contains(m.HTML, `<a href="http://192.168.1.20:8888/login?a=1&amp;b=2">`) {
+		t.Errorf("got HTML %q", m.HTML)
+	}
+}
+
+func TestUnmatchedNotice(t *testing.T) {
+	recorded := time.Date(2024, 5, 17, 21, 30, 0, 0, time.Local)
+	rec := structs.Recognition{
+		Timestamp: recorded.UnixMilli(),
+		Track: structs.Track{
+			Title:    "Rock & Roll <Live>",
+			Subtitle: "The Band",
+			Isrc:     "USABC0000001",
+			Url:      "https://www.shazam.com/track/1",
+			Image:    structs.TrackImages{CoverArt: "https://example.com/cover.jpg"},
+		},
+	}
+	n := &notifier{device: "kitchen"}
+	m, err := n.message(unmatchedNotice, recognitionData(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "Not found on Spotify: Rock & Roll <Live> by The Band" {
+		t.Errorf("got subject %q", m.Subject)
+	}
+	for _, want := range []string{
+		"kitchen recognized Rock & Roll <Live> by The Band on Friday 17 May 21:30",
+		"ISRC: USABC0000001",
+		"Shazam: https://www.shazam.com/track/1",
+	} {
+		if !strings.Contains(m.Text, want) {
+			t.Errorf("text lacks %q: %q", want, m.Text)
+		}
+	}
+	for _, want := range []string{
+		"<b>Rock &amp; Roll &lt;Live&gt;</b>",
+		`<img src="https://example.com/cover.jpg"`,
+		"<li>ISRC: USABC0000001</li>",
+		`<a href="https://www.shazam.com/track/1">`,
+	} {
+		if !strings.Contains(m.HTML, want) {
+			t.Errorf("HTML lacks %q: %q", want, m.HTML)
+		}
+	}
+
+	// what is not known is left out
+	m, err = n.message(unmatchedNotice, recognitionData(structs.Recognition{Timestamp: rec.Timestamp, Track: structs.Track{Title: "Song", Subtitle: "Band"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(m.Text, "ISRC") || strings.Contains(m.Text, "Shazam:") || strings.Contains(m.HTML, "<img") || strings.Contains(m.HTML, "<li>") {
+		t.Errorf("got %q and %q", m.Text, m.HTML)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"shazammini/src/audio"
+	"shazammini/src/queue"
+	"shazammini/src/structs"
+	"shazammini/src/utils"
+	"time"
+)
+
+// pendingRecording is a recording made while the recognizer could not be reached
+type pendingRecording struct {
+	RecordedAt int64 `json:"recorded_at"` // milliseconds since epoch
+	// Recognition is set once recognized, when Spotify could not be reached
+	// to add it, so that it is not sent to the recognizer again
+	Recognition *structs.Recognition `json:"recognition,omitempty"`
+}
+
+// offlineQueue keeps recordings on disk until the recognizer is reachable again
+type offlineQueue struct {
+	queue *queue.Queue
+	retry time.Duration
+}
+
+func newOfflineQueue(dir string, retry time.Duration) (*offlineQueue, error) {
+	q, err := queue.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &offlineQueue{queue: q, retry: retry}, nil
+}
+
+// add saves a recording and returns the number of recordings waiting
+func (o *offlineQueue) add(clip audio.Clip, recordedAt time.Time) (int, error) {
+	_, err := o.queue.Push(pendingRecording{RecordedAt: recordedAt.UnixMilli()}, clip.EncodeWAV())
+	if err != nil {
+		return 0, err
+	}
+	return o.queue.Len(), nil
+}
+
+// drain waits for the device to be online and recognizes the queued
+// recordings, oldest first, passing every result to handle. Recordings whose
+// result handle fails to add stay queued with it
+func (o *offlineQueue) drain(recognizer Recognizer, handle func(structs.Recognition) error) {
+	for {
+		time.Sleep(o.retry)
+
+		if o.queue.Len() == 0 || !utils.Connected() {
+			continue
+		}
+
+		ids, err := o.queue.IDs()
+		if err != nil {
+			log.Println("Could not list offline queue:", err)
+			continue
+		}
+
+		for _, id := range ids {
+			if !o.process(id, recognizer, handle) {
+				break
+			}
+		}
+	}
+}
+
+// process recognizes one queued recording. It returns false when the
+// recognizer or Spotify is unreachable again and draining should stop
+func (o *offlineQueue) process(id string, recognizer Recognizer, handle func(structs.Recognition) error) bool {
+	pending := pendingRecording{}
+	if err := o.queue.Load(id, &pending); err != nil {
+		log.Printf("Dropping unreadable queued recording %s: %v", id, err)
+		o.queue.Remove(id)
+		return true
+	}
+
+	if pending.Recognition == nil {
+		clip, err := audio.ReadWAV(o.queue.Attachment(id))
+		if err != nil {
+			log.Printf("Dropping unreadable queued recording %s: %v", id, err)
+			o.queue.Remove(id)
+			return true
+		}
+
+		rec, err := recognizer.Recognize(context.Background(), clip)
+		switch {
+		case errors.Is(err, ErrUnreachable):
+			return false
+		case errors.Is(err, ErrNoMatch):
+			log.Printf("No match for recording queued at %s", time.UnixMilli(pending.RecordedAt))
+		case err != nil:
+			log.Printf("Could not recognize queued recording %s, will retry: %v", id, err)
+			return false
+		default:
+			rec.Timestamp = pending.RecordedAt
+			log.Printf("Recognized queued recording: %s by %s", rec.Track.Title, rec.Track.Subtitle)
+			pending.Recognition = &rec
+		}
+	}
+
+	if pending.Recognition != nil {
+		if err := handle(*pending.Recognition); err != nil {
+			log.Printf("Could not add queued recording %s, will retry: %v", id, err)
+			if err := o.queue.Update(id, pending); err != nil {
+				log.Printf("Could not keep the recognition of %s: %v", id, err)
+			}
+			return false
+		}
+	}
+
+	if err := o.queue.Remove(id); err != nil {
+		log.Printf("Could not remove %s from offline queue: %v", id, err)
+	}
+	return true
+}
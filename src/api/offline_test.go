@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+// countingRecognizer counts the recordings sent to the recognizer
+type countingRecognizer struct {
+	Recognizer
+	calls int
+}
+
+func (c *countingRecognizer) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	c.calls++
+	return c.Recognizer.Recognize(ctx, clip)
+}
+
+func TestOfflineQueueKeepsUnaddedRecognitions(t *testing.T) {
+	o, err := newOfflineQueue(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedAt := time.Date(2024, 5, 17, 21, 30, 0, 0, time.Local)
+	if _, err := o.add(numberedClip(1), recordedAt); err != nil {
+		t.Fatal(err)
+	}
+	recognizer := &countingRecognizer{Recognizer: stubBackend{"a"}}
+
+	var handled []structs.Recognition
+	failures := []error{
+		fmt.Errorf("%w: timeout", ErrSpotifyUnreachable),
+		&SpotifyError{Status: 502},
+		nil,
+	}
+	handle := func(rec structs.Recognition) error {
+		handled = append(handled, rec)
+		return failures[len(handled)-1]
+	}
+
+	for i := range failures {
+		ids, _ := o.queue.IDs()
+		if len(ids) != 1 {
+			t.Fatalf("%d recordings queued after %d attempts, want 1", len(ids), i)
+		}
+		if got, want := o.process(ids[0], recognizer, handle), failures[i] == nil; got != want {
+			t.Errorf("attempt %d: draining goes on %v, want %v", i+1, got, want)
+		}
+	}
+
+	if o.queue.Len() != 0 {
+		t.Error("added recording still queued")
+	}
+	if recognizer.calls != 1 {
+		t.Errorf("recognized %d times, want once", recognizer.calls)
+	}
+	for _, rec := range handled {
+		if rec.Track.Key != "a" || rec.Timestamp != recordedAt.UnixMilli() {
+			t.Errorf("handled %+v", rec)
+		}
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{fmt.Errorf("%w: connection refused", ErrSpotifyUnreachable), true},
+		{&SpotifyError{Status: 429}, true},
+		{fmt.Errorf("add: %w", &SpotifyError{Status: 503}), true},
+		{&SpotifyError{Status: 403}, false},
+		{ErrAlreadyInPlaylist, false},
+		{&NoConfidentMatchError{}, false},
+	}
+	for _, tt := range tests {
+		if got := temporary(tt.err); got != tt.want {
+			t.Errorf("temporary(%v) is %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
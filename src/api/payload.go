@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"shazammini/src/audio"
+	"time"
+)
+
+// The detect endpoint only accepts raw 44.1 kHz mono signed 16-bit
+// little-endian PCM of less than 500KB, encoded in base64
+const shazamSampleRate = 44100
+const shazamMaxPayload = 500 * 1000
+
+// shazamPayloadHop is the step used when looking for the loudest part of a clip too long for the endpoint
+const shazamPayloadHop = 250 * time.Millisecond
+
+var errEmptyClip = errors.New("the recording is empty")
+
+// buildShazamPayload converts a clip to the format expected by the detect
+// endpoint. Clips that do not fit in maxBytes are cut down to their loudest window
+func buildShazamPayload(clip audio.Clip, maxBytes int) (string, error) {
+	if clip.Frames() == 0 {
+		return "", errEmptyClip
+	}
+
+	pcm := clip.Mono().Resample(shazamSampleRate)
+
+	maxFrames := maxBytes / 2
+	if pcm.Frames() > maxFrames {
+		hop := int(shazamPayloadHop.Seconds() * shazamSampleRate)
+		pcm = pcm.LoudestWindow(maxFrames, hop)
+	}
+
+	return base64.StdEncoding.EncodeToString(pcm.EncodePCM()), nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"shazammini/src/audio"
+	"testing"
+)
+
+// stereoSine generates a tone on both channels, louder between loudFrom and
+// loudTo seconds
+func stereoSine(rate uint32, seconds, loudFrom, loudTo float64) audio.Clip {
+	frames := int(seconds * float64(rate))
+	samples := make([]int16, 0, 2*frames)
+	for i := 0; i < frames; i++ {
+		t := float64(i) / float64(rate)
+		amplitude := 500.0
+		if t >= loudFrom && t < loudTo {
+			amplitude = 20000
+		}
+		s := int16(amplitude * math.Sin(2*math.Pi*440*t))
+		samples = append(samples, s, s)
+	}
+	return audio.Clip{Samples: samples, SampleRate: rate, Channels: 2}
+}
+
+func TestBuildShazamPayload(t *testing.T) {
+	// longest clip that fits, in seconds
+	window := float64(shazamMaxPayload/2) / shazamSampleRate
+	tests := []struct {
+		name       string
+		clip       audio.Clip
+		wantFrames int
+		wantStart  float64 // seconds into the clip of the first frame sent
+	}{
+		{"short 44.1 kHz stereo", stereoSine(44100, 3, 0, 0), 3 * 44100, 0},
+		{"short 48 kHz stereo", stereoSine(48000, 3, 0, 0), 3 * 44100, 0},
+		{"long with loud start", stereoSine(48000, 20, 0, window), shazamMaxPayload / 2, 0},
+		{"long with loud middle", stereoSine(48000, 20, 8, 8+window), shazamMaxPayload / 2, 8},
+		{"long with loud end", stereoSine(44100, 20, 20-window, 20), shazamMaxPayload / 2, 20 - window},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := buildShazamPayload(tt.clip, shazamMaxPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pcm, err := base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pcm) > shazamMaxPayload {
+				t.Errorf("payload is %d bytes, more than %d", len(pcm), shazamMaxPayload)
+			}
+			// one 16-bit channel at 44.1 kHz
+			if got := len(pcm) / 2; got != tt.wantFrames {
+				t.Errorf("got %d frames, want %d", got, tt.wantFrames)
+			}
+
+			want := tt.clip.Mono().Resample(shazamSampleRate)
+			start := int(math.Round(tt.wantStart * shazamSampleRate))
+			hop := int(shazamPayloadHop.Seconds() * shazamSampleRate)
+			// windows start every hop
+			start = start / hop * hop
+			for i := 0; i < len(pcm)/2; i += 101 {
+				got := int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+				if got != want.Samples[start+i] {
+					t.Fatalf("frame %d is %d, want %d: not the loudest window", i, got, want.Samples[start+i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildShazamPayloadEmpty(t *testing.T) {
+	_, err := buildShazamPayload(audio.Clip{SampleRate: 44100, Channels: 2}, shazamMaxPayload)
+	if !errors.Is(err, errEmptyClip) {
+		t.Errorf("got %v, want %v", err, errEmptyClip)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"shazammini/src/utils"
+)
+
+// ErrAlreadyInPlaylist is returned when the track was added to the playlist before
+var ErrAlreadyInPlaylist = errors.New("track already in playlist")
+
+// playlistContents is the list of tracks of a playlist at a given snapshot
+type playlistContents struct {
+	PlaylistID string   `json:"playlist_id"`
+	SnapshotID string   `json:"snapshot_id"`
+	URIs       []string `json:"uris"`
+}
+
+func (p playlistContents) contains(uri string) bool {
+	for _, u := range p.URIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// playlistCache keeps the contents of the playlist on disk so that checking
+// for duplicates only costs a request for the snapshot id while nobody else
+// edits the playlist
+type playlistCache struct {
+	client    *spotifyClient
+	path      string
+	apiURL    string
+	playlists map[string]playlistContents
+}
+
+func newPlaylistCache(client *spotifyClient, path, apiURL string) *playlistCache {
+	c := &playlistCache{client: client, path: path, apiURL: apiURL, playlists: map[string]playlistContents{}}
+
+	// a missing or broken cache is simply fetched again
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &c.playlists)
+	}
+	return c
+}
+
+// Contains tells whether the playlist already holds the track
+func (c *playlistCache) Contains(token, playlistID, uri string) (bool, error) {
+	snapshot, err := c.snapshot(token, playlistID)
+	if err != nil {
+		return false, err
+	}
+
+	if c.playlists[playlistID].SnapshotID != snapshot {
+		if err := c.fetch(token, playlistID, snapshot); err != nil {
+			return false, err
+		}
+	}
+	return c.playlists[playlistID].contains(uri), nil
+}
+
+// Added records a track we added ourselves, snapshot is the id Spotify returned for the change
+func (c *playlistCache) Added(playlistID, snapshot, uri string) error {
+	contents, ok := c.playlists[playlistID]
+	if !ok {
+		// nothing to update, the next check fetches the playlist anyway
+		return nil
+	}
+	contents.SnapshotID = snapshot
+	contents.URIs = append(contents.URIs, uri)
+	c.playlists[playlistID] = contents
+	return c.save()
+}
+
+// Removed records a track we removed ourselves
+func (c *playlistCache) Removed(playlistID, snapshot, uri string) error {
+	contents, ok := c.playlists[playlistID]
+	if !ok {
+		return nil
+	}
+	uris := contents.URIs[:0]
+	for _, u := range contents.URIs {
+		if u != uri {
+			uris = append(uris, u)
+		}
+	}
+	contents.SnapshotID = snapshot
+	contents.URIs = uris
+	c.playlists[playlistID] = contents
+	return c.save()
+}
+
+func (c *playlistCache) snapshot(token, playlistID string) (string, error) {
+	var playlist struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	u := c.apiURL + "/playlists/" + url.PathEscape(playlistID) + "?fields=snapshot_id"
+	if err := c.client.getJSON(token, u, &playlist); err != nil {
+		return "", fmt.Errorf("could not get playlist snapshot: %w", err)
+	}
+	return playlist.SnapshotID, nil
+}
+
+// fetch reads every page of the playlist
+func (c *playlistCache) fetch(token, playlistID, snapshot string) error {
+	contents := playlistContents{PlaylistID: playlistID, SnapshotID: snapshot}
+
+	next := c.apiURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks?" +
+		url.Values{"fields": {"next,items(track(uri))"}, "limit": {"100"}}.Encode()
+	for next != "" {
+		var page struct {
+			Next  string `json:"next"`
+			Items []struct {
+				Track *struct {
+					URI string `json:"uri"`
+				} `json:"track"`
+			} `json:"items"`
+		}
+		if err := c.client.getJSON(token, next, &page); err != nil {
+			return fmt.Errorf("could not get playlist tracks: %w", err)
+		}
+		for _, item := range page.Items {
+			// removed tracks come back without details
+			if item.Track != nil && item.Track.URI != "" {
+				contents.URIs = append(contents.URIs, item.Track.URI)
+			}
+		}
+		next = page.Next
+	}
+
+	c.playlists[playlistID] = contents
+	return c.save()
+}
+
+func (c *playlistCache) save() error {
+	data, err := json.Marshal(c.playlists)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(c.path, data, 0644)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+)
+
+// ErrNoMatch is returned by a Recognizer when the clip could not be identified
+var ErrNoMatch = errors.New("no match found")
+
+// ErrUnreachable is returned by a Recognizer that could not reach its service
+var ErrUnreachable = errors.New("recognizer unreachable")
+
+// Recognizer identifies the song playing in a clip
+type Recognizer interface {
+	Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error)
+}
+
+// newRecognizer returns the backend selected in the [Recognizer] section of
+// creds.toml, voting over windows of the recording
+func newRecognizer(cfg Config) Recognizer {
+	agreement := cfg.Recognizer.Agreement
+	if agreement < 1 {
+		agreement = 2
+	}
+
+	return &windowedRecognizer{
+		recognizer:  newBackend(cfg),
+		window:      seconds(cfg.Recognizer.Window),
+		hop:         seconds(cfg.Recognizer.Hop),
+		concurrency: cfg.Recognizer.Concurrency,
+		agreement:   agreement,
+	}
+}
+
+func newBackend(cfg Config) Recognizer {
+	switch cfg.Recognizer.Backend {
+	case "", "shazam":
+		endpoint := cfg.shazamEndpoint()
+		host := "shazam.p.rapidapi.com"
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		return &shazamAPI{
+			url:  endpoint + "/songs/v2/detect?timezone=Europe%2FParis&locale=fr-FR",
+			host: host,
+			key:  cfg.Shazam.Key,
+		}
+	case "local":
+		local, err := newLocalRecognizer(cfg.FingerprintIndex(), cfg.FingerprintMinScore())
+		if err != nil {
+			log.Fatalf("Could not load fingerprint index: %v", err)
+		}
+		return local
+	case "fake":
+		fake, err := newFakeRecognizer(cfg.Recognizer.Fixture)
+		if err != nil {
+			log.Fatalf("Could not load recognizer fixture: %v", err)
+		}
+		return fake
+	default:
+		log.Fatalf("Unknown recognizer backend %q", cfg.Recognizer.Backend)
+	}
+	return nil
+}
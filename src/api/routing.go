@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"shazammini/src/structs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteRule sends the tracks matching every condition set to its playlists
+type RouteRule struct {
+	Genre     string   `toml:"genre"`     // primary genre, case insensitive
+	Hours     string   `toml:"hours"`     // local hours the track was recorded at, "22-6" wraps around midnight
+	Artist    string   `toml:"artist"`    // regular expression matched against the artists, case insensitive
+	Explicit  *bool    `toml:"explicit"`  // explicit lyrics or not
+	Playlists []string `toml:"playlists"` // where the matching tracks go
+}
+
+type route struct {
+	genre     string
+	hours     bool
+	from, to  int
+	artist    *regexp.Regexp
+	explicit  *bool
+	playlists []string
+}
+
+// router picks the playlists of a track with the first rule it matches,
+// tracks matching no rule go to the default playlist
+type router struct {
+	routes   []route
+	fallback []string
+}
+
+func newRouter(rules []RouteRule, fallback string) (*router, error) {
+	r := &router{}
+	if fallback != "" {
+		r.fallback = []string{fallback}
+	}
+
+	for i, rule := range rules {
+		rt := route{
+			genre:     strings.ToLower(strings.TrimSpace(rule.Genre)),
+			explicit:  rule.Explicit,
+			playlists: rule.Playlists,
+		}
+		if len(rule.Playlists) == 0 {
+			return nil, fmt.Errorf("routing rule %d has no playlists", i+1)
+		}
+		if rule.Hours != "" {
+			from, to, err := parseHours(rule.Hours)
+			if err != nil {
+				return nil, fmt.Errorf("routing rule %d: %w", i+1, err)
+			}
+			rt.hours, rt.from, rt.to = true, from, to
+		}
+		if rule.Artist != "" {
+			artist, err := regexp.Compile("(?i)" + rule.Artist)
+			if err != nil {
+				return nil, fmt.Errorf("routing rule %d: %w", i+1, err)
+			}
+			rt.artist = artist
+		}
+		r.routes = append(r.routes, rt)
+	}
+	return r, nil
+}
+
+// parseHours reads "from-to" where both are hours of the day and to is excluded
+func parseHours(hours string) (int, int, error) {
+	bounds := strings.Split(hours, "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("hours %q are not like 22-6", hours)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil || from < 0 || from > 23 {
+		return 0, 0, fmt.Errorf("hours %q do not start with an hour of the day", hours)
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil || to < 0 || to > 24 {
+		return 0, 0, fmt.Errorf("hours %q do not end with an hour of the day", hours)
+	}
+	return from, to, nil
+}
+
+// Playlists returns the playlists the recognized track goes to
+func (r *router) Playlists(rec structs.Recognition) []string {
+	for _, rt := range r.routes {
+		if rt.matches(rec) {
+			return rt.playlists
+		}
+	}
+	return r.fallback
+}
+
+func (rt route) matches(rec structs.Recognition) bool {
+	track := rec.Track
+
+	if rt.genre != "" && rt.genre != strings.ToLower(strings.TrimSpace(track.Genre.Primary)) {
+		return false
+	}
+	if rt.explicit != nil && *rt.explicit != track.Hub.Explicit {
+		return false
+	}
+	if rt.hours && !rt.inHours(recordedAt(rec).Hour()) {
+		return false
+	}
+	if rt.artist != nil {
+		names := []string{track.Subtitle}
+		for _, a := range track.Artists {
+			names = append(names, a.Name)
+		}
+		found := false
+		for _, name := range names {
+			if name != "" && rt.artist.MatchString(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (rt route) inHours(hour int) bool {
+	if rt.from <= rt.to {
+		return hour >= rt.from && hour < rt.to
+	}
+	return hour >= rt.from || hour < rt.to
+}
+
+// recordedAt is the local time the recording was made at
+func recordedAt(rec structs.Recognition) time.Time {
+	if rec.Timestamp == 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(rec.Timestamp)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"reflect"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+func recognitionAt(hour int, genre, artist string, explicit bool) structs.Recognition {
+	recorded := time.Date(2024, 5, 17, hour, 30, 0, 0, time.Local)
+	return structs.Recognition{
+		Timestamp: recorded.UnixMilli(),
+		Track: structs.Track{
+			Title:    "Song",
+			Subtitle: artist,
+			Genre:    structs.Genre{Primary: genre},
+			Hub:      structs.Hub{Explicit: explicit},
+		},
+	}
+}
+
+func TestRouterPlaylists(t *testing.T) {
+	yes, no := true, false
+	rules := []RouteRule{
+		{Genre: "Jazz", Playlists: []string{"jazz"}},
+		{Artist: "^daft punk$", Playlists: []string{"daft"}},
+		{Genre: "electronic", Hours: "22-6", Playlists: []string{"night", "electro"}},
+		{Explicit: &yes, Playlists: []string{"explicit"}},
+		{Explicit: &no, Genre: "Rock", Playlists: []string{"clean rock"}},
+		{Genre: "electronic", Playlists: []string{"electro"}},
+	}
+	r, err := newRouter(rules, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		rec  structs.Recognition
+		want []string
+	}{
+		{"genre", recognitionAt(12, "Jazz", "Miles Davis", false), []string{"jazz"}},
+		{"genre ignores case and spaces", recognitionAt(12, " JAZZ ", "Miles Davis", false), []string{"jazz"}},
+		{"first match wins", recognitionAt(12, "jazz", "Daft Punk", true), []string{"jazz"}},
+		{"artist ignores case", recognitionAt(12, "Pop", "DAFT PUNK", false), []string{"daft"}},
+		{"artist is a whole match", recognitionAt(12, "Pop", "Daft Punk & Friends", false), []string{"default"}},
+		{"hours before midnight", recognitionAt(23, "Electronic", "Justice", false), []string{"night", "electro"}},
+		{"hours after midnight", recognitionAt(3, "Electronic", "Justice", false), []string{"night", "electro"}},
+		{"end hour excluded", recognitionAt(6, "Electronic", "Justice", false), []string{"electro"}},
+		{"outside hours", recognitionAt(14, "Electronic", "Justice", false), []string{"electro"}},
+		{"explicit", recognitionAt(12, "Rock", "Band", true), []string{"explicit"}},
+		{"not explicit", recognitionAt(12, "Rock", "Band", false), []string{"clean rock"}},
+		{"explicit unset matches both", recognitionAt(12, "Pop", "Singer", true), []string{"explicit"}},
+		{"fallback", recognitionAt(12, "Classical", "Bach", false), []string{"default"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Playlists(tt.rec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterArtists(t *testing.T) {
+	r, err := newRouter([]RouteRule{{Artist: "punk", Playlists: []string{"punk"}}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := recognitionAt(12, "Pop", "Someone feat. Others", false)
+	rec.Track.Artists = []structs.Artist{{Name: "Someone"}, {Name: "Daft Punk"}}
+	if got := r.Playlists(rec); !reflect.DeepEqual(got, []string{"punk"}) {
+		t.Errorf("got %v, want the artists to be matched", got)
+	}
+	if got := r.Playlists(recognitionAt(12, "Pop", "Someone", false)); got != nil {
+		t.Errorf("got %v without a default playlist, want none", got)
+	}
+}
+
+func TestRouteInHours(t *testing.T) {
+	tests := []struct {
+		hours string
+		in    []int
+		out   []int
+	}{
+		{"9-17", []int{9, 12, 16}, []int{8, 17, 23, 0}},
+		{"22-6", []int{22, 23, 0, 5}, []int{6, 12, 21}},
+		{"0-24", []int{0, 12, 23}, nil},
+		{"5-5", nil, []int{0, 5, 23}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hours, func(t *testing.T) {
+			from, to, err := parseHours(tt.hours)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt := route{hours: true, from: from, to: to}
+			for _, h := range tt.in {
+				if !rt.inHours(h) {
+					t.Errorf("%d:00 is not in %s", h, tt.hours)
+				}
+			}
+			for _, h := range tt.out {
+				if rt.inHours(h) {
+					t.Errorf("%d:00 is in %s", h, tt.hours)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		hours    string
+		from, to int
+		ok       bool
+	}{
+		{"22-6", 22, 6, true},
+		{" 9 - 17 ", 9, 17, true},
+		{"0-24", 0, 24, true},
+		{"", 0, 0, false},
+		{"22", 0, 0, false},
+		{"1-2-3", 0, 0, false},
+		{"a-6", 0, 0, false},
+		{"22-b", 0, 0, false},
+		{"24-6", 0, 0, false},
+		{"-1-6", 0, 0, false},
+		{"22-25", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hours, func(t *testing.T) {
+			from, to, err := parseHours(tt.hours)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && (from != tt.from || to != tt.to) {
+				t.Errorf("got %d-%d, want %d-%d", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestNewRouterRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RouteRule
+	}{
+		{"no playlists", RouteRule{Genre: "Jazz"}},
+		{"bad hours", RouteRule{Hours: "late", Playlists: []string{"p"}}},
+		{"bad artist", RouteRule{Artist: "(", Playlists: []string{"p"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRouter([]RouteRule{tt.rule}, "default"); err == nil {
+				t.Error("rule accepted")
+			}
+		})
+	}
+}
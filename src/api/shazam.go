@@ -1,13 +1,13 @@
 package api
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"shazammini/src/audio"
 	"shazammini/src/structs"
 	"strings"
 )
@@ -20,35 +20,35 @@ type ShazamResponse struct {
 	Track     structs.Track   `json:"track"`
 }
 
-type shazamAPI struct {
-	url      string
-	host     string
-	key      string
-	payload  *strings.Reader
-	response ShazamResponse
-}
-
-func (s *shazamAPI) ReadFile() {
-	file, err := os.Open("temp/output.wav")
-	if err != nil {
-		panic(err)
+// recognition normalizes the response, Shazam answers without a track when nothing matched
+func (r ShazamResponse) recognition() (structs.Recognition, error) {
+	if r.Track.Key == "" || len(r.Matches) == 0 {
+		return structs.Recognition{}, ErrNoMatch
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
-	if err != nil {
-		panic(err)
-	}
-
-	encodedString := base64.StdEncoding.EncodeToString(data)
+	return structs.Recognition{
+		Track:     r.Track,
+		Matches:   r.Matches,
+		Timestamp: r.Timestamp,
+		Timezone:  r.Timezone,
+		TagID:     r.TagID,
+		// Shazam does not score its matches
+		Confidence: 1,
+	}, nil
+}
 
-	s.payload = strings.NewReader(encodedString)
+type shazamAPI struct {
+	url  string
+	host string
+	key  string
 }
 
-func (s *shazamAPI) CallAPI() {
-	req, err := http.NewRequest("POST", s.url, s.payload)
+func (s *shazamAPI) CallAPI(ctx context.Context, payload io.Reader) (ShazamResponse, error) {
+	response := ShazamResponse{}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, payload)
 	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+		return response, err
 	}
 
 	req.Header.Add("content-type", "text/plain")
@@ -57,24 +57,39 @@ func (s *shazamAPI) CallAPI() {
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+		return response, fmt.Errorf("%w: %v", ErrUnreachable, err)
 	}
 	defer res.Body.Close()
 
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return response, err
+	}
 
-	s.response = ShazamResponse{}
+	if res.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("unexpected status code from Shazam: %d", res.StatusCode)
+	}
 
-	err = json.Unmarshal(body, &s.response)
+	err = json.Unmarshal(body, &response)
 	if err != nil {
-		log.Println(err)
-		fmt.Println(string(body))
+		log.Printf("Undecodable Shazam response: %s", body)
+		return response, fmt.Errorf("could not decode Shazam response: %w", err)
 	}
+	return response, nil
 }
 
-func (s *shazamAPI) GetSong() {
-	s.ReadFile()
-	s.CallAPI()
+func (s *shazamAPI) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	payload, err := buildShazamPayload(clip, shazamMaxPayload)
+	if err != nil {
+		return structs.Recognition{}, err
+	}
+
+	response, err := s.CallAPI(ctx, strings.NewReader(payload))
+	if err != nil {
+		return structs.Recognition{}, err
+	}
 
+	rec, err := response.recognition()
+	rec.Backend = "shazam"
+	return rec, err
 }
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"shazammini/src/history"
+	"shazammini/src/queue"
+	"shazammini/src/structs"
+	"time"
+)
+
+// Sink receives every recognized track, next to the Spotify playlist
+type Sink interface {
+	Name() string
+	Send(rec structs.Recognition) error
+}
+
+// limitedSink gives up on a recognition after some failed sends. attempt
+// counts the sends of the recognition from 1, across retries and restarts
+type limitedSink interface {
+	Sink
+	SendAttempt(rec structs.Recognition, attempt int) error
+}
+
+// queuedRecognition is a recognition waiting for a sink. Queues written by
+// older versions hold bare recognitions, read as never sent
+type queuedRecognition struct {
+	structs.Recognition
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// permanentError marks a failure that sending again cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// deliveryReport is told where a recognition stands with a sink, one of the
+// history delivery statuses
+type deliveryReport func(sink string, rec structs.Recognition, status string, err error)
+
+// sinkQueue keeps the recognitions a sink has not received yet on disk, so
+// that they survive failures and restarts and are sent in order
+type sinkQueue struct {
+	sink   Sink
+	queue  *queue.Queue
+	wake   chan bool
+	report deliveryReport
+}
+
+// sinks delivers recognitions to every sink in the background
+type sinks struct {
+	queues []*sinkQueue
+	retry  time.Duration
+}
+
+// newSinks opens a queue for each sink under dir and starts delivering
+func newSinks(dir string, retry time.Duration, report deliveryReport, list ...Sink) (*sinks, error) {
+	s := &sinks{retry: retry}
+	for _, sink := range list {
+		q, err := queue.Open(filepath.Join(dir, sink.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not open %s queue: %w", sink.Name(), err)
+		}
+		sq := &sinkQueue{sink: sink, queue: q, wake: make(chan bool, 1), report: report}
+		s.queues = append(s.queues, sq)
+		go sq.run(retry)
+	}
+	return s, nil
+}
+
+// Deliver queues the recognition for every sink
+func (s *sinks) Deliver(rec structs.Recognition) {
+	for _, sq := range s.queues {
+		// reported before the worker can see it, so that it never overwrites the delivery
+		sq.report(sq.sink.Name(), rec, history.Pending, nil)
+		if _, err := sq.queue.Push(queuedRecognition{Recognition: rec}, nil); err != nil {
+			log.Printf("Could not queue recognition for %s: %v", sq.sink.Name(), err)
+			sq.report(sq.sink.Name(), rec, history.Dropped, err)
+			continue
+		}
+		select {
+		case sq.wake <- true:
+		default:
+		}
+	}
+}
+
+func (sq *sinkQueue) run(retry time.Duration) {
+	for {
+		sq.flush()
+		select {
+		case <-sq.wake:
+		case <-time.After(retry):
+		}
+	}
+}
+
+// flush sends the queued recognitions, oldest first, until one fails
+func (sq *sinkQueue) flush() {
+	ids, err := sq.queue.IDs()
+	if err != nil {
+		log.Printf("Could not list %s queue: %v", sq.sink.Name(), err)
+		return
+	}
+
+	for _, id := range ids {
+		item := queuedRecognition{}
+		if err := sq.queue.Load(id, &item); err != nil {
+			log.Printf("Dropping unreadable recognition %s for %s: %v", id, sq.sink.Name(), err)
+			sq.queue.Remove(id)
+			continue
+		}
+		rec := item.Recognition
+		item.Attempts++
+
+		var err error
+		if limited, ok := sq.sink.(limitedSink); ok {
+			err = limited.SendAttempt(rec, item.Attempts)
+		} else {
+			err = sq.sink.Send(rec)
+		}
+		var perm permanentError
+		switch {
+		case errors.As(err, &perm):
+			log.Printf("Dropping %s for %s: %v", rec.Track.Title, sq.sink.Name(), err)
+			sq.report(sq.sink.Name(), rec, history.Dropped, err)
+		case err != nil:
+			log.Printf("Could not send %s to %s, will retry: %v", rec.Track.Title, sq.sink.Name(), err)
+			sq.report(sq.sink.Name(), rec, history.Pending, err)
+			if err := sq.queue.Update(id, item); err != nil {
+				log.Printf("Could not count the attempt to send %s to %s: %v", rec.Track.Title, sq.sink.Name(), err)
+			}
+			return
+		default:
+			sq.report(sq.sink.Name(), rec, history.Delivered, nil)
+		}
+		sq.queue.Remove(id)
+	}
+}
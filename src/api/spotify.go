@@ -1,33 +1,21 @@
 package api
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
-	"net/http"
-	"net/url"
-	"os"
 	"shazammini/src/structs"
 	"strings"
 	"time"
-
-	"github.com/pelletier/go-toml"
 )
 
-const redirectURI = "http://localhost:8080/callback"
-
-var loginChan = make(chan bool)
-
 type SpotifyTokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	TokenType    string `json:"token_type"`
 	ExpiresIn    int    `json:"expires_in"`
 	ExpiresAt    int64  `json:"expires_at"`
 	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
 }
 
 type SotifyResponse struct {
@@ -42,315 +30,254 @@ type Tracks struct {
 	Items  []Items `json:"items"`
 }
 type Items struct {
-	Type string `json:"type"`
-	Uri  string `json:"uri"`
+	Type        string `json:"type"`
+	Uri         string `json:"uri"`
+	Name        string `json:"name"`
+	DurationMs  int    `json:"duration_ms"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+	Artists []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
 }
 
-type spotifyAPI struct {
-	add_playlist_url string
-	search_url       string
-	host             string
-	clientID         string
-	clientSecret     string
-	data             string
-	playlist_id      string
-	token_search     SpotifyTokenResponse
-	token_login      SpotifyTokenResponse
-	expireTime       int64
-	state            string
+func (i Items) describe() string {
+	if len(i.Artists) == 0 {
+		return i.Name
+	}
+	return i.Name + " by " + i.Artists[0].Name
 }
 
-func sendEmail(text string) {
-	fmt.Println("Please log in to Spotify by visiting the following page in your browser:", text)
-
+type spotifyAPI struct {
+	add_playlist_url    string
+	search_url          string
+	remove_playlist_url string
+	library_url         string
+	liked_songs         bool
+	favorites_id        string
+	clientID            string
+	client              *spotifyClient
+	routes              *router
+	tokens              *tokenManager
+	playlists           *playlistCache
+	monthly             *monthlyPlaylists
+	login               loginConfig
+	notify              *notifier
+	commChannels        *structs.CommChannels
 }
 
-func generateRandomString(length int) string {
-	seed := time.Now().UnixNano()
-	rng := rand.New(rand.NewSource(seed))
-
-	// Available characters for random string generation
-	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-	// Generate random string
-	var sb strings.Builder
-	for i := 0; i < length; i++ {
-		index := rng.Intn(len(charset))
-		sb.WriteByte(charset[index])
+func (s *spotifyAPI) AddToPlaylist(playlistID, trackUri string) error {
+	token, err := s.tokens.UserToken()
+	if err != nil {
+		return err
 	}
 
-	return sb.String()
-}
-
-func (s *spotifyAPI) completeAuth(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
-	code := queryParams.Get("code")
-	state := queryParams.Get("state")
+	add := struct {
+		URIs []string `json:"uris"`
+	}{URIs: []string{trackUri}}
 
-	if state != s.state {
-		log.Fatalf("States are different. This is a security risk... %s vs %s", state, s.state)
+	var added struct {
+		SnapshotID string `json:"snapshot_id"`
 	}
-
-	Authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(s.clientID+":"+s.clientSecret))
-
-	data := url.Values{}
-	data.Set("grant_type", "authorization_code")
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
-	requestBody := strings.NewReader(data.Encode())
-
-	req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", requestBody)
-	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+	url := strings.Replace(s.add_playlist_url, "{playlist_id}", playlistID, 1)
+	if err := s.client.callJSON("POST", token, url, add, &added); err != nil {
+		return fmt.Errorf("could not add track to playlist: %w", err)
 	}
+	return s.playlists.Added(playlistID, added.SnapshotID, trackUri)
+}
 
-	req.Header.Add("content-type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", Authorization)
-
-	res, err := http.DefaultClient.Do(req)
+// checkDuplicate returns ErrAlreadyInPlaylist when the track is in the playlist
+func (s *spotifyAPI) checkDuplicate(playlistID, trackUri string) error {
+	token, err := s.tokens.UserToken()
 	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+		return err
 	}
-	defer res.Body.Close()
-
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
-
-	err = json.Unmarshal(body, &s.token_login)
+	found, err := s.playlists.Contains(token, playlistID, trackUri)
 	if err != nil {
-		log.Fatalf("Could not decode response JSON: %s", err)
+		return err
 	}
-
-	currentTime := time.Now().Unix()
-	s.token_login.ExpiresAt = currentTime + int64(s.token_login.ExpiresIn)
-
-	loginChan <- true
-
+	if found {
+		return ErrAlreadyInPlaylist
+	}
+	return nil
 }
 
-func (s *spotifyAPI) RefreshToken() {
-
-	Authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(s.clientID+":"+s.clientSecret))
-
-	data := url.Values{}
-	data.Set("grant_type", "refresh_token")
-	data.Set("refresh_token", s.token_login.RefreshToken)
-	requestBody := strings.NewReader(data.Encode())
-
-	req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", requestBody)
+func (s *spotifyAPI) RemoveFromPlaylist(playlistID, trackUri string) error {
+	token, err := s.tokens.UserToken()
 	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+		return err
 	}
 
-	req.Header.Add("content-type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", Authorization)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+	type item struct {
+		URI string `json:"uri"`
 	}
-	defer res.Body.Close()
+	remove := struct {
+		Tracks []item `json:"tracks"`
+	}{Tracks: []item{{URI: trackUri}}}
 
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
-
-	err = json.Unmarshal(body, &s.token_login)
-	if err != nil {
-		log.Fatalf("Could not decode response JSON: %s", err)
+	var removed struct {
+		SnapshotID string `json:"snapshot_id"`
 	}
-
-	currentTime := time.Now().Unix()
-	s.token_login.ExpiresAt = currentTime + int64(s.token_login.ExpiresIn)
-
+	url := strings.Replace(s.remove_playlist_url, "{playlist_id}", playlistID, 1)
+	if err := s.client.callJSON("DELETE", token, url, remove, &removed); err != nil {
+		return fmt.Errorf("could not remove track from playlist: %w", err)
+	}
+	return s.playlists.Removed(playlistID, removed.SnapshotID, trackUri)
 }
 
-func (s *spotifyAPI) Login() {
-	// first start an HTTP server
-	http.HandleFunc("/callback", s.completeAuth)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Got request for:", r.URL.String())
-	})
-	go func() {
-		err := http.ListenAndServe(":8080", nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	s.state = generateRandomString(16)
-	scope := "user-read-private  playlist-modify-private playlist-read-private playlist-read-collaborative playlist-modify-public"
-
-	url := "https://accounts.spotify.com/authorize?" +
-		url.Values{
-			"response_type": {"code"},
-			"client_id":     {s.clientID},
-			"scope":         {scope},
-			"redirect_uri":  {redirectURI},
-			"state":         {s.state},
-		}.Encode()
-	sendEmail(url)
+func (s *spotifyAPI) EstablishAcces() error {
+	if s.tokens.LoggedIn() {
+		return nil
+	}
+	return s.Login()
 }
 
-func (s *spotifyAPI) GetAccessToken() {
-
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", s.clientID)
-	data.Set("client_secret", s.clientSecret)
+// playlistItem is a track added to a playlist
+type playlistItem struct {
+	PlaylistID string
+	URI        string
+}
 
-	requestBody := strings.NewReader(data.Encode())
+// AddSong adds the recognized track to the playlists it is routed to and
+// returns where it was added, or ErrAlreadyInPlaylist when they all hold it already.
+// The Spotify track is noted in the recognition as soon as it is found
+func (s *spotifyAPI) AddSong(rec *structs.Recognition) ([]playlistItem, error) {
+	track := rec.Track
 
-	url := "https://accounts.spotify.com/api/token"
-	req, err := http.NewRequest("POST", url, requestBody)
-	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+	if err := s.EstablishAcces(); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+	trackUri, err := s.FindTrack(&track, songPosition(*rec))
+	var noMatch *NoConfidentMatchError
+	if errors.As(err, &noMatch) {
+		s.notify.Unmatched(*rec)
 	}
-	defer res.Body.Close()
-
-	body, _ := io.ReadAll(res.Body)
-
-	err = json.Unmarshal(body, &s.token_search)
 	if err != nil {
-		log.Fatalf("Could not decode response JSON: %s", err)
+		return nil, err
 	}
-
-	currentTime := time.Now().Unix()
-	s.token_search.ExpiresAt = currentTime + int64(s.token_search.ExpiresIn)
-	cfg := Config{}.loadToml()
-	cfg.Spotify.TokenSearch = s.token_search
-	cfg.saveToml()
+	rec.SpotifyURI = trackUri
+	return s.AddTrack(*rec, trackUri)
 }
 
-func (s *spotifyAPI) SearchTrack(uri string) string {
-	currentTime := time.Now().Unix()
-	if currentTime >= s.expireTime {
-		s.GetAccessToken()
-	}
-
-	url := strings.Replace(s.search_url, "{uri}", uri, 1)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+// AddTrack adds the Spotify track picked for the recognition to the playlists it is routed to
+func (s *spotifyAPI) AddTrack(rec structs.Recognition, trackUri string) ([]playlistItem, error) {
+	playlists := s.routes.Playlists(rec)
+	if len(playlists) == 0 {
+		return nil, errors.New("no playlist to add the track to")
 	}
 
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+s.token_search.AccessToken)
-	req.Header.Add("X-RapidAPI-Host", s.host)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+	var added []playlistItem
+	var failed error
+	duplicates := 0
+	for _, playlistID := range playlists {
+		playlistID, err := s.resolvePlaylist(playlistID, recordedAt(rec))
+		if err != nil {
+			log.Println("Could not find playlist:", err)
+			failed = err
+			continue
+		}
+		if err := s.checkDuplicate(playlistID, trackUri); errors.Is(err, ErrAlreadyInPlaylist) {
+			log.Printf("%s is already in playlist %s", trackUri, playlistID)
+			duplicates++
+			continue
+		} else if err != nil {
+			// better a duplicate than a lost song
+			log.Println("Could not check the playlist for duplicates:", err)
+		}
+		if err := s.AddToPlaylist(playlistID, trackUri); err != nil {
+			log.Println("Could not add track to playlist:", err)
+			failed = err
+			continue
+		}
+		added = append(added, playlistItem{PlaylistID: playlistID, URI: trackUri})
 	}
-	defer res.Body.Close()
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
-
-	if res.StatusCode != http.StatusOK {
-		log.Fatalf("Unexpected status code: %d", res.StatusCode)
+	if duplicates == len(playlists) {
+		return nil, ErrAlreadyInPlaylist
 	}
-	var spotifyReponse SotifyResponse
-	err = json.Unmarshal(body, &spotifyReponse)
-	if err != nil {
-		log.Fatalf("Could not decode response JSON: %s", err)
+	if len(added) == 0 && failed != nil {
+		return nil, failed
 	}
-
-	fmt.Println(spotifyReponse)
-
-	return spotifyReponse.Tracks.Items[0].Uri
+	return added, nil
 }
 
-func (s *spotifyAPI) AddToPlaylist(trackUri string) {
-	currentTime := time.Now().Unix()
-	if currentTime >= s.expireTime {
-		s.RefreshToken()
+// RemoveSong takes back tracks added by AddSong
+func (s *spotifyAPI) RemoveSong(items []playlistItem) error {
+	for _, item := range items {
+		if err := s.RemoveFromPlaylist(item.PlaylistID, item.URI); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	url := strings.Replace(s.add_playlist_url, "{playlist_id}", s.playlist_id, 1)
-	url = strings.Replace(url, "{track_ui}", trackUri, 1)
+// ErrNoFavorites is returned when neither the Liked Songs nor a favorites playlist are set up
+var ErrNoFavorites = errors.New("no favorites set up")
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(s.data)))
-	if err != nil {
-		log.Fatalf("Could not get res %s", err)
+// SaveFavorite saves the recognized track to the Liked Songs and to the favorites playlist
+func (s *spotifyAPI) SaveFavorite(rec structs.Recognition) error {
+	if !s.liked_songs && s.favorites_id == "" {
+		return ErrNoFavorites
 	}
-	req.Header.Set("Authorization", "Bearer "+s.token_login.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Printf("Could not get res %s", err)
+	if err := s.EstablishAcces(); err != nil {
+		return err
 	}
-	defer res.Body.Close()
 
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
-
-	if res.StatusCode != http.StatusCreated {
-		log.Fatalf("Unexpected status code: %d", res.StatusCode)
+	// the track picked in confirm mode or found when adding it
+	trackUri := rec.SpotifyURI
+	if trackUri == "" {
+		var err error
+		trackUri, err = s.FindTrack(&rec.Track, songPosition(rec))
+		if err != nil {
+			return err
+		}
 	}
 
-}
-
-func (s *spotifyAPI) LoadTokens() {
-
-	tomlFile := "toeks.toml"
-	file, err := os.Open(tomlFile)
-	if err != nil {
-		log.Fatalf("Failed to open TOML file: %v", err)
+	if s.liked_songs {
+		token, err := s.tokens.UserToken()
+		if err != nil {
+			return err
+		}
+		ids := struct {
+			IDs []string `json:"ids"`
+		}{IDs: []string{strings.TrimPrefix(trackUri, "spotify:track:")}}
+		if err := s.client.callJSON("PUT", token, s.library_url, ids, nil); err != nil {
+			return fmt.Errorf("could not save track to Liked Songs: %w", err)
+		}
 	}
-	defer file.Close()
 
-	var cfg Config
-	if err := toml.NewDecoder(file).Decode(&cfg); err != nil {
-		log.Fatalf("Failed to parse TOML: %v", err)
+	if s.favorites_id != "" {
+		playlistID, err := s.resolvePlaylist(s.favorites_id, recordedAt(rec))
+		if err != nil {
+			return err
+		}
+		if err := s.checkDuplicate(playlistID, trackUri); errors.Is(err, ErrAlreadyInPlaylist) {
+			return nil
+		} else if err != nil {
+			log.Println("Could not check the favorites for duplicates:", err)
+		}
+		return s.AddToPlaylist(playlistID, trackUri)
 	}
+	return nil
 }
 
-func (s *spotifyAPI) EstablishAcces() {
-	if s.token_login.AccessToken == "" || s.token_login.RefreshToken == "" {
-		s.Login()
-		<-loginChan
-
-		cfg := Config{}.loadToml()
-		cfg.Spotify.TokenLogin = s.token_login
-		cfg.saveToml()
-
-	}
-
-	currentTime := time.Now().Unix()
-	if currentTime >= s.token_login.ExpiresAt {
-		s.RefreshToken()
+// resolvePlaylist turns the monthly placeholder into the id of the playlist of the month
+func (s *spotifyAPI) resolvePlaylist(playlistID string, at time.Time) (string, error) {
+	if playlistID != monthlyPlaylist {
+		return playlistID, nil
 	}
-
-	if s.token_search.AccessToken == "" {
-		s.GetAccessToken()
-
-		cfg := Config{}.loadToml()
-		cfg.Spotify.TokenLogin = s.token_login
-		cfg.saveToml()
-
+	token, err := s.tokens.UserToken()
+	if err != nil {
+		return "", err
 	}
+	return s.monthly.ID(token, at)
 }
 
-func (s *spotifyAPI) AddSong(song *ShazamResponse) structs.Track {
-
-	s.EstablishAcces()
-
-	for _, provider := range song.Track.Hub.Providers {
-		if provider.Type == "SPOTIFY" {
-			uri := provider.Actions[0].Uri
-			trackUri := s.SearchTrack(uri)
-			s.AddToPlaylist(trackUri)
-			return song.Track
-		}
+// songPosition is how far into the song the recording was made
+func songPosition(rec structs.Recognition) time.Duration {
+	if len(rec.Matches) == 0 {
+		return 0
 	}
-	fmt.Println(song.Track.Hub.Providers)
-	fmt.Println("Could not find track in spotify. Need to send email with info")
-	return song.Track
+	return seconds(rec.Matches[0].Offset)
 }
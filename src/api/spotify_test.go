@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+// testSpotify returns a client of a Spotify stand-in, logged in with tokens
+// that do not need refreshing
+func testSpotify(t *testing.T, handler http.Handler) *spotifyAPI {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	expires := time.Now().Add(time.Hour).Unix()
+	client := newSpotifyClient(5 * time.Second)
+	tokens := &tokenManager{
+		client:   client,
+		path:     filepath.Join(t.TempDir(), "tokens.toml"),
+		tokenURL: srv.URL + "/api/token",
+		clientID: "id",
+	}
+	tokens.tokens.User = SpotifyTokenResponse{AccessToken: "user", Scope: loginScope, ExpiresAt: expires}
+	tokens.tokens.App = SpotifyTokenResponse{AccessToken: "app", ExpiresAt: expires}
+	tokens.tokens.UserPKCE = true
+
+	routes, err := newRouter(nil, "playlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &spotifyAPI{
+		add_playlist_url: srv.URL + "/v1/playlists/{playlist_id}/tracks",
+		search_url:       srv.URL + "/v1/search",
+		library_url:      srv.URL + "/v1/me/tracks",
+		client:           client,
+		routes:           routes,
+		tokens:           tokens,
+		playlists:        newPlaylistCache(client, filepath.Join(t.TempDir(), "playlists.json"), srv.URL+"/v1"),
+	}
+}
+
+func TestSaveFavoriteKeepsPickedTrack(t *testing.T) {
+	var saved []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("searched again for a track already known")
+		json.NewEncoder(w).Encode(SotifyResponse{})
+	})
+	mux.HandleFunc("/v1/me/tracks", func(w http.ResponseWriter, r *http.Request) {
+		ids := struct {
+			IDs []string `json:"ids"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&ids)
+		saved = append(saved, ids.IDs...)
+	})
+	s := testSpotify(t, mux)
+	s.liked_songs = true
+
+	rec := structs.Recognition{
+		Track:      structs.Track{Title: "Song", Subtitle: "Band"},
+		SpotifyURI: "spotify:track:picked",
+	}
+	if err := s.SaveFavorite(rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0] != "picked" {
+		t.Errorf("saved %v, want the picked track", saved)
+	}
+}
+
+func TestSearchToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   string
+	}{
+		{"without secret", "", "Bearer user"},
+		{"with secret", "secret", "Bearer app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var auth string
+			s := testSpotify(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				json.NewEncoder(w).Encode(SotifyResponse{})
+			}))
+			s.tokens.clientSecret = tt.secret
+			if _, err := s.search("isrc:USQX91300108"); err != nil {
+				t.Fatal(err)
+			}
+			if auth != tt.want {
+				t.Errorf("searched with %q, want %q", auth, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddRecognitionPublishesSpotifyTrack(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tracks":{"items":[{"uri":"spotify:track:found","name":"Get Lucky","duration_ms":248000,
+			"external_ids":{"isrc":"USQX91300108"},"artists":[{"name":"Daft Punk"}]}]}}`)
+	})
+	mux.HandleFunc("/v1/playlists/playlist", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusNotFound)
+	})
+	mux.HandleFunc("/v1/playlists/playlist/tracks", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"snapshot_id":"2"}`)
+	})
+	s := testSpotify(t, mux)
+
+	bus := structs.NewStatusBus()
+	rec := structs.Recognition{Track: structs.Track{Title: "Get Lucky", Subtitle: "Daft Punk", Isrc: "USQX91300108"}}
+	bus.SetResult(rec)
+	statuses := bus.Subscribe()
+	<-statuses
+
+	added, err := addRecognition(bus, &rec, s.AddSong)
+	if err != nil || len(added) != 1 {
+		t.Fatalf("got %v, %v", added, err)
+	}
+	status := <-statuses
+	if status.State != structs.StateResult || status.Track == nil || status.Track.SpotifyURI != "spotify:track:found" {
+		t.Errorf("got status %+v, want the result with its Spotify track", status)
+	}
+}
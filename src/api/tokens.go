@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"shazammini/src/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// tokens are refreshed when they expire in less than tokenExpiryMargin
+const tokenExpiryMargin = time.Minute
+
+// ErrNotLoggedIn is returned when no Spotify user has logged in yet
+var ErrNotLoggedIn = errors.New("not logged in to Spotify")
+
+type spotifyTokens struct {
+	// User is obtained by logging in and is needed to modify playlists
+	User SpotifyTokenResponse `toml:"User"`
+	// App uses the client credentials flow and is enough to search
+	App SpotifyTokenResponse `toml:"App"`
+	// UserPKCE is set when the user token was obtained without the client
+	// secret, it must then be refreshed without it too
+	UserPKCE bool `toml:"UserPKCE"`
+}
+
+// tokenManager hands out valid Spotify tokens, refreshing them only when
+// they are about to expire, and keeps them in their own file
+type tokenManager struct {
+	client       *spotifyClient
+	lock         sync.Mutex
+	path         string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	tokens       spotifyTokens
+}
+
+// newTokenManager loads the tokens saved in path. The first time, tokens
+// saved in creds.toml by older versions are moved to path
+func newTokenManager(client *spotifyClient, path, tokenURL string, cfg Config) (*tokenManager, error) {
+	m := &tokenManager{
+		client:       client,
+		path:         path,
+		tokenURL:     tokenURL,
+		clientID:     cfg.Spotify.ClientID,
+		clientSecret: cfg.Spotify.ClientSecret,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		m.tokens.User = cfg.Spotify.TokenLogin
+		m.tokens.App = cfg.Spotify.TokenSearch
+		return m, m.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := toml.Unmarshal(data, &m.tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return m, nil
+}
+
+func (m *tokenManager) save() error {
+	data, err := toml.Marshal(m.tokens)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(m.path, data, 0600)
+}
+
+func fresh(token SpotifyTokenResponse) bool {
+	return token.AccessToken != "" && time.Now().Add(tokenExpiryMargin).Unix() < token.ExpiresAt
+}
+
+// LoggedIn reports whether a user token, or the means to refresh it, is available
+func (m *tokenManager) LoggedIn() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !hasScopes(m.tokens.User.Scope, loginScope) {
+		// logged in before ShazPi needed more permissions
+		return false
+	}
+	return m.tokens.User.RefreshToken != "" || fresh(m.tokens.User)
+}
+
+// hasScopes tells whether granted holds every scope of wanted
+func hasScopes(granted, wanted string) bool {
+	for _, scope := range strings.Fields(wanted) {
+		found := false
+		for _, g := range strings.Fields(granted) {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthorizePKCE exchanges the code obtained by logging in with PKCE for a user token
+func (m *tokenManager) AuthorizePKCE(code, redirectURI, verifier string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	token, err := m.request(form, false)
+	if err != nil {
+		return fmt.Errorf("could not get Spotify user token: %w", err)
+	}
+
+	m.tokens.User = m.stamp(token, m.tokens.User)
+	m.tokens.UserPKCE = true
+	return m.save()
+}
+
+// UserToken returns the access token of the logged in user
+func (m *tokenManager) UserToken() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if fresh(m.tokens.User) {
+		return m.tokens.User.AccessToken, nil
+	}
+	if m.tokens.User.RefreshToken == "" {
+		return "", ErrNotLoggedIn
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", m.tokens.User.RefreshToken)
+	token, err := m.request(form, !m.tokens.UserPKCE)
+	if err != nil {
+		return "", fmt.Errorf("could not refresh Spotify user token: %w", err)
+	}
+
+	m.tokens.User = m.stamp(token, m.tokens.User)
+	if err := m.save(); err != nil {
+		return "", err
+	}
+	return m.tokens.User.AccessToken, nil
+}
+
+// SearchToken returns the token to search with, the application token when
+// the client secret is set, otherwise the user token since logging in with
+// PKCE needs no secret on the device
+func (m *tokenManager) SearchToken() (string, error) {
+	if m.clientSecret == "" {
+		return m.UserToken()
+	}
+	return m.AppToken()
+}
+
+// AppToken returns an access token of the application itself
+func (m *tokenManager) AppToken() (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if fresh(m.tokens.App) {
+		return m.tokens.App.AccessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	token, err := m.request(form, true)
+	if err != nil {
+		return "", fmt.Errorf("could not get Spotify app token: %w", err)
+	}
+
+	m.tokens.App = m.stamp(token, m.tokens.App)
+	if err := m.save(); err != nil {
+		return "", err
+	}
+	return m.tokens.App.AccessToken, nil
+}
+
+// stamp computes when a new token expires and keeps the previous refresh
+// token when Spotify does not send a new one
+func (m *tokenManager) stamp(token, previous SpotifyTokenResponse) SpotifyTokenResponse {
+	token.ExpiresAt = time.Now().Unix() + int64(token.ExpiresIn)
+	if token.RefreshToken == "" {
+		token.RefreshToken = previous.RefreshToken
+	}
+	if token.Scope == "" {
+		token.Scope = previous.Scope
+	}
+	return token
+}
+
+// request calls the token endpoint. Confidential requests authenticate with
+// the client secret, the others only identify the client
+func (m *tokenManager) request(form url.Values, confidential bool) (SpotifyTokenResponse, error) {
+	token := SpotifyTokenResponse{}
+
+	if !confidential {
+		form.Set("client_id", m.clientID)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if confidential {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(m.clientID+":"+m.clientSecret)))
+	}
+
+	body, err := m.client.Do("POST", m.tokenURL, header, []byte(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return token, fmt.Errorf("could not decode response JSON: %w", err)
+	}
+	if token.AccessToken == "" {
+		return token, errors.New("no access token in response")
+	}
+	return token, nil
+}
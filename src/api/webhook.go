@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"shazammini/src/structs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook is an address told about every recognition
+type Webhook struct {
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"` // signs the body with HMAC-SHA256 when set
+}
+
+// webhookDocument is the JSON posted to webhooks
+type webhookDocument struct {
+	Title      string    `json:"title"`
+	Artists    []string  `json:"artists"`
+	ISRC       string    `json:"isrc,omitempty"`
+	CoverArt   string    `json:"cover_art,omitempty"`
+	SpotifyURI string    `json:"spotify_uri,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Device     string    `json:"device"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// webhookSink posts every recognition to one webhook. Failed posts are sent
+// again with the queue, up to attempts times whatever the failure, then the
+// document is written to the dead letter file so that a broken or vanished
+// webhook does not hold the recognitions after it forever
+type webhookSink struct {
+	hook       Webhook
+	device     string
+	attempts   int
+	http       *http.Client
+	deadLetter *deadLetter
+}
+
+// deadLetter appends the documents no webhook accepted to a file, one JSON per line
+type deadLetter struct {
+	path string
+	lock sync.Mutex
+}
+
+type deadLetterEntry struct {
+	URL      string          `json:"url"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+	Document json.RawMessage `json:"document"`
+}
+
+// DeviceName identifies this ShazPi to the services it reports to
+func (cfg Config) DeviceName() string {
+	if cfg.Device.Name != "" {
+		return cfg.Device.Name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "shazpi"
+}
+
+func (cfg Config) webhookAttempts() int {
+	if cfg.Webhooks.Attempts <= 0 {
+		return 3
+	}
+	return cfg.Webhooks.Attempts
+}
+
+func (cfg Config) webhookDeadLetter() string {
+	if cfg.Webhooks.DeadLetter == "" {
+		return "webhook_dead_letters.jsonl"
+	}
+	return cfg.Webhooks.DeadLetter
+}
+
+// newWebhookSinks returns a sink for each webhook, they share the dead letter file
+func newWebhookSinks(cfg Config) []Sink {
+	dead := &deadLetter{path: cfg.webhookDeadLetter()}
+	var list []Sink
+	for _, hook := range cfg.Webhooks.Hooks {
+		if hook.URL == "" {
+			continue
+		}
+		list = append(list, &webhookSink{
+			hook:       hook,
+			device:     cfg.DeviceName(),
+			attempts:   cfg.webhookAttempts(),
+			http:       &http.Client{Timeout: 10 * time.Second},
+			deadLetter: dead,
+		})
+	}
+	return list
+}
+
+// Name is derived from the address so that every webhook keeps its queue
+// when the list is reordered
+func (w *webhookSink) Name() string {
+	sum := sha256.Sum256([]byte(w.hook.URL))
+	return "webhook-" + hex.EncodeToString(sum[:4])
+}
+
+func (w *webhookSink) Send(rec structs.Recognition) error {
+	return w.SendAttempt(rec, 1)
+}
+
+func (w *webhookSink) SendAttempt(rec structs.Recognition, attempt int) error {
+	data, err := json.Marshal(newWebhookDocument(rec, w.device))
+	if err != nil {
+		return permanent(err)
+	}
+
+	status, err := w.post(data)
+	if err == nil {
+		return nil
+	}
+	// unreachable, overloaded or down, the queue sends it again later
+	retryable := status == 0 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+	if retryable && attempt < w.attempts {
+		return err
+	}
+
+	if err := w.deadLetter.add(w.hook.URL, data, err); err != nil {
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+	return permanent(err)
+}
+
+// post returns the status answered, 0 when the webhook could not be reached
+func (w *webhookSink) post(data []byte) (int, error) {
+	req, err := http.NewRequest("POST", w.hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ShazPi")
+	if w.hook.Secret != "" {
+		req.Header.Set("X-ShazPi-Signature", "sha256="+sign(w.hook.Secret, data))
+	}
+
+	res, err := w.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res.StatusCode, nil
+	}
+	return res.StatusCode, fmt.Errorf("webhook answered %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// sign is the hex HMAC-SHA256 of the body, receivers compute it again with
+// the shared secret to check where the document comes from
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookDocument(rec structs.Recognition, device string) webhookDocument {
+	var artists []string
+	for _, artist := range rec.Track.Artists {
+		if artist.Name != "" {
+			artists = append(artists, artist.Name)
+		}
+	}
+	if len(artists) == 0 && rec.Track.Subtitle != "" {
+		artists = []string{rec.Track.Subtitle}
+	}
+	return webhookDocument{
+		Title:      rec.Track.Title,
+		Artists:    artists,
+		ISRC:       rec.Track.Isrc,
+		CoverArt:   rec.Track.Image.CoverArt,
+		SpotifyURI: rec.SpotifyURI,
+		Confidence: rec.Confidence,
+		Device:     device,
+		Timestamp:  recordedAt(rec),
+	}
+}
+
+func (d *deadLetter) add(url string, document []byte, failure error) error {
+	line, err := json.Marshal(deadLetterEntry{
+		URL:      url,
+		Error:    failure.Error(),
+		FailedAt: time.Now(),
+		Document: document,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"shazammini/src/history"
+	"shazammini/src/queue"
+	"shazammini/src/structs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deliveries collects the delivery reports of the sinks
+type deliveries struct {
+	lock     sync.Mutex
+	statuses []string
+}
+
+func (d *deliveries) report(sink string, rec structs.Recognition, status string, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.statuses = append(d.statuses, status)
+}
+
+func (d *deliveries) last() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.statuses) == 0 {
+		return ""
+	}
+	return d.statuses[len(d.statuses)-1]
+}
+
+func deadLetters(t *testing.T, path string) []deadLetterEntry {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []deadLetterEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := deadLetterEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	var posts int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		http.Error(w, "oops", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusBadRequest)
+	}))
+	defer refusing.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		attempts int // sends before the dead letter
+	}{
+		{"unreachable", down.URL, 3},
+		{"server errors", failing.URL, 3},
+		{"refused", refusing.URL, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink := &webhookSink{
+				hook:       Webhook{URL: tt.url},
+				device:     "test",
+				attempts:   3,
+				http:       &http.Client{Timeout: time.Second},
+				deadLetter: &deadLetter{path: filepath.Join(dir, "dead.jsonl")},
+			}
+			q, err := queue.Open(filepath.Join(dir, sink.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a recognition queued by an older version
+			if _, err := q.Push(structs.Recognition{Track: structs.Track{Title: "Song"}}, nil); err != nil {
+				t.Fatal(err)
+			}
+			reports := &deliveries{}
+			sq := &sinkQueue{sink: sink, queue: q, wake: make(chan bool, 1), report: reports.report}
+
+			for attempt := 1; attempt <= tt.attempts; attempt++ {
+				if got := len(deadLetters(t, sink.deadLetter.path)); got != 0 {
+					t.Fatalf("dead letter written after %d attempts", attempt-1)
+				}
+				sq.flush()
+			}
+
+			if q.Len() != 0 {
+				t.Errorf("still queued after %d attempts", tt.attempts)
+			}
+			if got := reports.last(); got != history.Dropped {
+				t.Errorf("reported %s, want %s", got, history.Dropped)
+			}
+			letters := deadLetters(t, sink.deadLetter.path)
+			if len(letters) != 1 || letters[0].URL != tt.url {
+				t.Fatalf("got dead letters %+v", letters)
+			}
+			doc := webhookDocument{}
+			if err := json.Unmarshal(letters[0].Document, &doc); err != nil || doc.Title != "Song" {
+				t.Errorf("got document %s", letters[0].Document)
+			}
+		})
+	}
+	if posts != 3 {
+		t.Errorf("failing webhook got %d posts, want 3", posts)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+	"time"
+)
+
+// windowedRecognizer splits a long recording into overlapping windows,
+// recognizes them with the wrapped backend and picks the track most windows
+// agree on. It stops submitting windows as soon as enough of them agree
+type windowedRecognizer struct {
+	recognizer  Recognizer
+	window      time.Duration
+	hop         time.Duration
+	concurrency int
+	agreement   int
+}
+
+type windowResult struct {
+	rec structs.Recognition
+	err error
+}
+
+// windows returns the [start, end) frames of every window of the clip. The
+// last window is aligned on the end of the clip so no audio is left out
+func (w *windowedRecognizer) windows(clip audio.Clip) [][2]int {
+	size := int(w.window.Seconds() * float64(clip.SampleRate))
+	hop := int(w.hop.Seconds() * float64(clip.SampleRate))
+	frames := clip.Frames()
+	if size <= 0 || hop <= 0 || frames <= size {
+		return [][2]int{{0, frames}}
+	}
+
+	var windows [][2]int
+	start := 0
+	for ; start+size <= frames; start += hop {
+		windows = append(windows, [2]int{start, start + size})
+	}
+	if start-hop+size < frames {
+		windows = append(windows, [2]int{frames - size, frames})
+	}
+	return windows
+}
+
+// voteKey identifies the track of a recognition, falling back on the match
+// ID for backends that do not return a track key
+func voteKey(rec structs.Recognition) string {
+	if rec.Track.Key != "" {
+		return rec.Track.Key
+	}
+	if len(rec.Matches) > 0 {
+		return rec.Matches[0].ID
+	}
+	return ""
+}
+
+func (w *windowedRecognizer) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	windows := w.windows(clip)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := w.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make(chan windowResult, len(windows))
+
+	next, running := 0, 0
+	submit := func() {
+		window := windows[next]
+		go func() {
+			rec, err := w.recognizer.Recognize(ctx, clip.Slice(window[0], window[1]))
+			results <- windowResult{rec: rec, err: err}
+		}()
+		next++
+		running++
+	}
+	for running < concurrency && next < len(windows) {
+		submit()
+	}
+
+	votes := map[string]int{}
+	best := map[string]structs.Recognition{}
+	attempted := 0
+	agreed := false
+	var failure error
+
+	for running > 0 {
+		result := <-results
+		running--
+
+		if agreed && result.err != nil && ctx.Err() != nil {
+			// windows interrupted by the early stop do not count, backends
+			// do not all keep the context error in the one they return
+			continue
+		}
+		attempted++
+
+		if result.err != nil {
+			if !errors.Is(result.err, ErrNoMatch) && failure == nil {
+				failure = result.err
+			}
+		} else {
+			key := voteKey(result.rec)
+			votes[key]++
+			if previous, ok := best[key]; !ok || result.rec.Confidence > previous.Confidence {
+				best[key] = result.rec
+			}
+			if votes[key] >= w.agreement {
+				agreed = true
+				cancel()
+			}
+		}
+
+		if !agreed && next < len(windows) {
+			submit()
+		}
+	}
+
+	winner := ""
+	for key, count := range votes {
+		if winner == "" || count > votes[winner] || (count == votes[winner] && best[key].Confidence > best[winner].Confidence) {
+			winner = key
+		}
+	}
+
+	if winner == "" {
+		if failure != nil {
+			return structs.Recognition{}, failure
+		}
+		return structs.Recognition{}, ErrNoMatch
+	}
+
+	rec := best[winner]
+	rec.Votes = votes[winner]
+	rec.Windows = attempted
+	rec.Confidence = float64(rec.Votes) / float64(attempted)
+	log.Printf("%d of %d windows agreed on %s", rec.Votes, rec.Windows, rec.Track.Title)
+	return rec, nil
+}
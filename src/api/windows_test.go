@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+// stubBackend answers every window with the track of its number, an empty
+// track for no match and "slow" for a window that waits for cancellation
+// then fails like the Shazam backend does
+type stubBackend []string
+
+func (s stubBackend) Recognize(ctx context.Context, clip audio.Clip) (structs.Recognition, error) {
+	// every sample of a window holds its number
+	switch key := s[clip.Samples[0]]; key {
+	case "":
+		return structs.Recognition{}, ErrNoMatch
+	case "slow":
+		<-ctx.Done()
+		return structs.Recognition{}, fmt.Errorf("%w: %v", ErrUnreachable, ctx.Err())
+	case "down":
+		return structs.Recognition{}, ErrUnreachable
+	default:
+		return structs.Recognition{Track: structs.Track{Key: key, Title: key}, Confidence: 1}, nil
+	}
+}
+
+// numberedClip is a clip of one second windows holding their number
+func numberedClip(windows int) audio.Clip {
+	const rate = 100
+	clip := audio.Clip{SampleRate: rate, Channels: 1}
+	for w := 0; w < windows; w++ {
+		for i := 0; i < rate; i++ {
+			clip.Samples = append(clip.Samples, int16(w))
+		}
+	}
+	return clip
+}
+
+func TestWindowedRecognizer(t *testing.T) {
+	tests := []struct {
+		name        string
+		answers     stubBackend
+		concurrency int
+		want        string
+		votes       int
+		windows     int
+	}{
+		{"all agree", stubBackend{"a", "a", "a"}, 1, "a", 2, 2},
+		{"misses count", stubBackend{"", "a", "b", "a"}, 1, "a", 2, 4},
+		{"majority", stubBackend{"a", "b", "", "b"}, 1, "b", 2, 4},
+		{"cancelled windows do not count", stubBackend{"slow", "a", "a"}, 3, "a", 2, 2},
+		{"cancelled windows do not count with default concurrency", stubBackend{"a", "slow", "a", "a"}, 2, "a", 2, 2},
+		{"failures count", stubBackend{"down", "a", "a"}, 1, "a", 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &windowedRecognizer{
+				recognizer:  tt.answers,
+				window:      time.Second,
+				hop:         time.Second,
+				concurrency: tt.concurrency,
+				agreement:   2,
+			}
+			rec, err := w.Recognize(context.Background(), numberedClip(len(tt.answers)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Track.Key != tt.want || rec.Votes != tt.votes || rec.Windows != tt.windows {
+				t.Errorf("got %q with %d/%d votes, want %q with %d/%d", rec.Track.Key, rec.Votes, rec.Windows, tt.want, tt.votes, tt.windows)
+			}
+			if want := float64(tt.votes) / float64(tt.windows); rec.Confidence != want {
+				t.Errorf("got confidence %.2f, want %.2f", rec.Confidence, want)
+			}
+		})
+	}
+}
+
+func TestWindowedRecognizerNoMatch(t *testing.T) {
+	w := &windowedRecognizer{recognizer: stubBackend{"", "", "down"}, window: time.Second, hop: time.Second, concurrency: 2, agreement: 2}
+	if _, err := w.Recognize(context.Background(), numberedClip(3)); err != ErrUnreachable {
+		t.Errorf("got %v, want the failure", err)
+	}
+	w.recognizer = stubBackend{"", ""}
+	if _, err := w.Recognize(context.Background(), numberedClip(2)); err != ErrNoMatch {
+		t.Errorf("got %v, want %v", err, ErrNoMatch)
+	}
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/youpy/go-wav"
+)
+
+// RecordingFile is where the microphone saves the last recording
+const RecordingFile = "temp/output.wav"
+
+// Clip is a block of signed 16-bit PCM audio. Samples are interleaved when
+// there is more than one channel
+type Clip struct {
+	Samples    []int16
+	SampleRate uint32
+	Channels   uint16
+}
+
+// Frames returns the number of samples per channel
+func (c Clip) Frames() int {
+	if c.Channels == 0 {
+		return 0
+	}
+	return len(c.Samples) / int(c.Channels)
+}
+
+func (c Clip) Duration() time.Duration {
+	if c.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(c.Frames()) * time.Second / time.Duration(c.SampleRate)
+}
+
+// ReadWAV loads a PCM WAV file, scaling samples of any bit depth to 16 bits
+func ReadWAV(path string) (Clip, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Clip{}, err
+	}
+	defer file.Close()
+
+	reader := wav.NewReader(file)
+	format, err := reader.Format()
+	if err != nil {
+		return Clip{}, fmt.Errorf("could not read WAV format of %s: %v", path, err)
+	}
+	if format.NumChannels < 1 || format.NumChannels > 2 {
+		return Clip{}, fmt.Errorf("unsupported number of channels in %s: %d", path, format.NumChannels)
+	}
+
+	clip := Clip{
+		SampleRate: format.SampleRate,
+		Channels:   format.NumChannels,
+	}
+
+	for {
+		samples, err := reader.ReadSamples()
+		if len(samples) == 0 || err != nil {
+			break
+		}
+		for _, sample := range samples {
+			for ch := uint(0); ch < uint(format.NumChannels); ch++ {
+				clip.Samples = append(clip.Samples, toInt16(reader.FloatValue(sample, ch)))
+			}
+		}
+	}
+
+	return clip, nil
+}
+
+func toInt16(v float64) int16 {
+	v *= 32768
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+// EncodeWAV returns the clip as the bytes of a 16-bit PCM WAV file
+func (c Clip) EncodeWAV() []byte {
+	dataSize := uint32(len(c.Samples) * 2)
+	blockAlign := c.Channels * 2
+
+	buf := bytes.NewBuffer(make([]byte, 0, 44+dataSize))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, 36+dataSize)
+	buf.WriteString("WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))
+	binary.Write(buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(buf, binary.LittleEndian, c.Channels)
+	binary.Write(buf, binary.LittleEndian, c.SampleRate)
+	binary.Write(buf, binary.LittleEndian, c.SampleRate*uint32(blockAlign))
+	binary.Write(buf, binary.LittleEndian, blockAlign)
+	binary.Write(buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, dataSize)
+	buf.Write(c.EncodePCM())
+	return buf.Bytes()
+}
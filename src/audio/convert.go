@@ -0,0 +1,119 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Mono mixes all channels down to a single one
+func (c Clip) Mono() Clip {
+	if c.Channels <= 1 {
+		return c
+	}
+
+	channels := int(c.Channels)
+	mono := make([]int16, c.Frames())
+	for i := range mono {
+		sum := 0
+		for ch := 0; ch < channels; ch++ {
+			sum += int(c.Samples[i*channels+ch])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return Clip{Samples: mono, SampleRate: c.SampleRate, Channels: 1}
+}
+
+// Resample converts a mono clip to the given sample rate using linear
+// interpolation. When downsampling, the clip is first smoothed with a moving
+// average as wide as the ratio to limit aliasing
+func (c Clip) Resample(rate uint32) Clip {
+	if c.SampleRate == rate || c.SampleRate == 0 || len(c.Samples) == 0 {
+		c.SampleRate = rate
+		return c
+	}
+
+	src := c.Samples
+	ratio := float64(c.SampleRate) / float64(rate)
+	if width := int(ratio); width > 1 {
+		src = movingAverage(src, width)
+	}
+
+	n := int(float64(len(src)) / ratio)
+	out := make([]int16, n)
+	for i := range out {
+		pos := float64(i) * ratio
+		j := int(pos)
+		frac := pos - float64(j)
+		next := j + 1
+		if next >= len(src) {
+			next = len(src) - 1
+		}
+		out[i] = int16(math.Round(float64(src[j])*(1-frac) + float64(src[next])*frac))
+	}
+	return Clip{Samples: out, SampleRate: rate, Channels: 1}
+}
+
+func movingAverage(samples []int16, width int) []int16 {
+	out := make([]int16, len(samples))
+	sum := 0
+	for i, s := range samples {
+		sum += int(s)
+		if i >= width {
+			sum -= int(samples[i-width])
+		}
+		n := width
+		if i+1 < width {
+			n = i + 1
+		}
+		out[i] = int16(sum / n)
+	}
+	return out
+}
+
+// Slice returns the frames in [start, end)
+func (c Clip) Slice(start, end int) Clip {
+	channels := int(c.Channels)
+	if start < 0 {
+		start = 0
+	}
+	if end > c.Frames() {
+		end = c.Frames()
+	}
+	if start > end {
+		start = end
+	}
+	return Clip{Samples: c.Samples[start*channels : end*channels], SampleRate: c.SampleRate, Channels: c.Channels}
+}
+
+// LoudestWindow returns the part of a mono clip of the given number of frames
+// with the most energy, looking at windows starting every hop frames
+func (c Clip) LoudestWindow(frames, hop int) Clip {
+	if frames >= c.Frames() {
+		return c
+	}
+	if hop < 1 {
+		hop = 1
+	}
+
+	// energy[i] is the energy of the first i samples
+	energy := make([]float64, len(c.Samples)+1)
+	for i, s := range c.Samples {
+		energy[i+1] = energy[i] + float64(s)*float64(s)
+	}
+
+	best, bestEnergy := 0, -1.0
+	for start := 0; start+frames <= len(c.Samples); start += hop {
+		if e := energy[start+frames] - energy[start]; e > bestEnergy {
+			best, bestEnergy = start, e
+		}
+	}
+	return c.Slice(best, best+frames)
+}
+
+// EncodePCM returns the samples as raw signed 16-bit little-endian PCM
+func (c Clip) EncodePCM() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, len(c.Samples)*2))
+	binary.Write(buf, binary.LittleEndian, c.Samples)
+	return buf.Bytes()
+}
@@ -0,0 +1,159 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sine generates a mono tone of the given frequency, amplitude and length
+func sine(freq float64, amplitude float64, rate uint32, frames int) Clip {
+	samples := make([]int16, frames)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return Clip{Samples: samples, SampleRate: rate, Channels: 1}
+}
+
+func TestMono(t *testing.T) {
+	tests := []struct {
+		name string
+		clip Clip
+		want []int16
+	}{
+		{"mono is kept", Clip{Samples: []int16{1, 2, 3}, SampleRate: 8000, Channels: 1}, []int16{1, 2, 3}},
+		{"stereo is averaged", Clip{Samples: []int16{100, 300, -50, 50, 32767, 32767}, SampleRate: 8000, Channels: 2}, []int16{200, 0, 32767}},
+		{"opposite channels cancel", Clip{Samples: []int16{1000, -1000, -32768, 32767}, SampleRate: 8000, Channels: 2}, []int16{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.clip.Mono()
+			if got.Channels != 1 {
+				t.Fatalf("got %d channels, want 1", got.Channels)
+			}
+			if got.SampleRate != tt.clip.SampleRate {
+				t.Errorf("sample rate changed to %d", got.SampleRate)
+			}
+			if len(got.Samples) != len(tt.want) {
+				t.Fatalf("got %d samples, want %d", len(got.Samples), len(tt.want))
+			}
+			for i := range tt.want {
+				if got.Samples[i] != tt.want[i] {
+					t.Errorf("sample %d is %d, want %d", i, got.Samples[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   uint32
+		to     uint32
+		frames int
+	}{
+		{"same rate", 44100, 44100, 44100},
+		{"down from 48 kHz", 48000, 44100, 48000},
+		{"up from 16 kHz", 16000, 44100, 16000},
+		{"down from 96 kHz", 96000, 44100, 96000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := sine(440, 10000, tt.from, tt.frames)
+			got := clip.Resample(tt.to)
+			if got.SampleRate != tt.to {
+				t.Fatalf("got %d Hz, want %d", got.SampleRate, tt.to)
+			}
+			if got.Channels != 1 {
+				t.Errorf("got %d channels, want 1", got.Channels)
+			}
+			if d := got.Duration() - clip.Duration(); d.Abs().Milliseconds() > 1 {
+				t.Errorf("duration changed from %s to %s", clip.Duration(), got.Duration())
+			}
+
+			// the tone is still a 440 Hz tone of about the same loudness
+			want := sine(440, 10000, tt.to, got.Frames())
+			for i := 100; i < got.Frames()-100; i += 97 {
+				if diff := math.Abs(float64(got.Samples[i]) - float64(want.Samples[i])); diff > 1500 {
+					t.Fatalf("sample %d is %d, want about %d", i, got.Samples[i], want.Samples[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResampleEmpty(t *testing.T) {
+	got := Clip{SampleRate: 48000, Channels: 1}.Resample(44100)
+	if got.SampleRate != 44100 || got.Frames() != 0 {
+		t.Errorf("got %d frames at %d Hz", got.Frames(), got.SampleRate)
+	}
+}
+
+func TestLoudestWindow(t *testing.T) {
+	const rate = 8000
+	quiet := sine(440, 100, rate, 4*rate)
+
+	tests := []struct {
+		name   string
+		loud   int // first frame of the loud second
+		frames int
+		hop    int
+		want   int // first frame of the window picked
+	}{
+		{"at the start", 0, rate, rate / 4, 0},
+		{"in the middle", 2 * rate, rate, rate / 4, 2 * rate},
+		{"at the end", 3 * rate, rate, rate / 4, 3 * rate},
+		{"between hops", rate + rate/8, rate, rate / 4, rate},
+		{"window longer than clip", rate, 8 * rate, rate, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := Clip{Samples: append([]int16(nil), quiet.Samples...), SampleRate: rate, Channels: 1}
+			copy(clip.Samples[tt.loud:], sine(440, 20000, rate, rate).Samples)
+
+			got := clip.LoudestWindow(tt.frames, tt.hop)
+			wantFrames := tt.frames
+			if wantFrames > clip.Frames() {
+				wantFrames = clip.Frames()
+			}
+			if got.Frames() != wantFrames {
+				t.Fatalf("got %d frames, want %d", got.Frames(), wantFrames)
+			}
+			if &got.Samples[0] != &clip.Samples[tt.want] {
+				t.Errorf("picked the wrong window, want the one at frame %d", tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodePCM(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int16
+		want    []byte
+	}{
+		{"empty", nil, []byte{}},
+		{"little endian", []int16{1, -1, 0x1234}, []byte{0x01, 0x00, 0xff, 0xff, 0x34, 0x12}},
+		{"extremes", []int16{32767, -32768}, []byte{0xff, 0x7f, 0x00, 0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Clip{Samples: tt.samples, SampleRate: 44100, Channels: 1}.EncodePCM()
+			if string(got) != string(tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+
+	clip := sine(1000, 30000, 44100, 44100)
+	pcm := clip.EncodePCM()
+	if len(pcm) != 2*len(clip.Samples) {
+		t.Fatalf("got %d bytes for %d samples", len(pcm), len(clip.Samples))
+	}
+	for i, s := range clip.Samples {
+		if got := int16(binary.LittleEndian.Uint16(pcm[2*i:])); got != s {
+			t.Fatalf("sample %d encoded as %d, want %d", i, got, s)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"shazammini/src/api"
+	"shazammini/src/audio"
+	"shazammini/src/export"
+	"shazammini/src/fingerprint"
+	"shazammini/src/history"
+	"shazammini/src/lastfm"
+	"time"
+)
+
+const commandsUsage = `Commands:
+  index build DIR   fingerprint every WAV file under DIR for the local recognizer
+  index query FILE  look for the song recorded in the WAV file FILE in the index
+  lastfm auth       allow ShazPi to scrobble to your Last.fm account
+  export FORMAT [FROM [TO]]
+                    write the history to stdout as csv, jsonl, m3u or xspf, from and
+                    to the days FROM and TO (YYYY-MM-DD) included
+`
+
+// runCommand runs one of the command line tools instead of the robots
+func runCommand(cfg api.Config, args []string) {
+	switch args[0] {
+	case "index":
+		indexCommand(cfg, args[1:])
+	case "lastfm":
+		lastfmCommand(cfg, args[1:])
+	case "export":
+		exportCommand(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], commandsUsage)
+		os.Exit(2)
+	}
+}
+
+func indexCommand(cfg api.Config, args []string) {
+	if len(args) != 2 {
+		fmt.Fprint(os.Stderr, commandsUsage)
+		os.Exit(2)
+	}
+	index := cfg.FingerprintIndex()
+
+	switch args[0] {
+	case "build":
+		db := fingerprint.New()
+		err := db.AddDir(args[1], func(path string, err error) {
+			if err != nil {
+				log.Printf("Skipping %s: %v", path, err)
+				return
+			}
+			log.Printf("Indexed %s", path)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.Save(index); err != nil {
+			log.Fatalf("Could not save index: %v", err)
+		}
+		fmt.Printf("Indexed %d songs (%d hashes) into %s\n", len(db.Songs), len(db.Index), index)
+
+	case "query":
+		db, err := fingerprint.Load(index)
+		if err != nil {
+			log.Fatalf("Could not load index: %v", err)
+		}
+		clip, err := audio.ReadWAV(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		match, ok := db.Match(clip, cfg.FingerprintMinScore())
+		if !ok {
+			fmt.Println("No match found")
+			os.Exit(1)
+		}
+		fmt.Printf("%s - %s\n", match.Song.Artist, match.Song.Title)
+		fmt.Printf("  score %d, confidence %.2f, offset %.1fs, %s\n", match.Score, match.Confidence, match.Offset, match.Song.Path)
+
+	default:
+		fmt.Fprint(os.Stderr, commandsUsage)
+		os.Exit(2)
+	}
+}
+
+func lastfmCommand(cfg api.Config, args []string) {
+	if len(args) != 1 || args[0] != "auth" {
+		fmt.Fprint(os.Stderr, commandsUsage)
+		os.Exit(2)
+	}
+	if cfg.Lastfm.APIKey == "" || cfg.Lastfm.Secret == "" {
+		log.Fatal("Set api_key and secret in the [Lastfm] section of creds.toml first")
+	}
+
+	client := cfg.LastfmClient()
+	token, err := client.Token()
+	if err != nil {
+		log.Fatalf("Could not start Last.fm authorization: %v", err)
+	}
+	fmt.Println("Allow ShazPi to scrobble by visiting the following page, then press enter:")
+	fmt.Println(client.AuthURL(token))
+	bufio.NewReader(os.Stdin).ReadString('\n')
+
+	key, err := client.Session(token)
+	if err != nil {
+		log.Fatalf("Could not get Last.fm session: %v", err)
+	}
+	if err := lastfm.SaveSession(cfg.LastfmSessionFile(), key); err != nil {
+		log.Fatalf("Could not save Last.fm session: %v", err)
+	}
+	fmt.Println("Saved Last.fm session to", cfg.LastfmSessionFile())
+}
+
+func exportCommand(cfg api.Config, args []string) {
+	if len(args) < 1 || len(args) > 3 || export.ContentType(args[0]) == "" {
+		fmt.Fprint(os.Stderr, commandsUsage)
+		os.Exit(2)
+	}
+	args = append(args, "", "")
+	q, err := export.ParseRange(args[1], args[2], time.Local)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := history.Open(cfg.HistoryFile())
+	if err != nil {
+		log.Fatalf("%v, the history cannot be read while ShazPi runs, use the export server instead", err)
+	}
+	defer store.Close()
+
+	entries, err := store.Query(q)
+	if err != nil {
+		log.Fatalf("Could not read history: %v", err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if err := export.Write(out, args[0], entries); err != nil {
+		log.Fatalf("Could not export history: %v", err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
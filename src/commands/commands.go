@@ -3,65 +3,99 @@ package commands
 import (
 	"fmt"
 	"shazammini/src/structs"
+	"time"
 
 	"gobot.io/x/gobot"
 )
 
-func run(commChannels *structs.CommChannels) {
+// Touch panel resolution and its centre, used by the simulator
+const EPD_WIDTH = 122
+const EPD_HEIGHT = 250
+const EPD_CENTER_X = 61
+const EPD_CENTER_Y = 125
+
+// choiceRow is the row of the display under the touch. The panel is used in
+// landscape, its X axis runs from the top of the display to the bottom
+func choiceRow(x, options int) int {
+	row := x * options / EPD_WIDTH
+	if row >= options {
+		row = options - 1
+	}
+	if row < 0 {
+		row = 0
+	}
+	return row
+}
+
+func run(commChannels *structs.CommChannels, recordDuration time.Duration) {
 
 	gt := GT1151{}
 	gt.New()
 	defer gt.Kill()
 
+	if virtual, ok := gt.i2c.(*VirtualGT1151); ok {
+		go readTouches(virtual)
+	}
+
 	GT_Dev := Development{}
 	GT_Old := Development{}
 
 	GT_Dev.Init()
 	GT_Old.Init()
 
+	gestures := gestureDetector{}
+	// the api waits for a tap on one of the options of the display
+	var choice *structs.Choice
+
 	for {
+		select {
+		case c := <-commChannels.Choose:
+			choice = &c
+		default:
+		}
+		if choice != nil && time.Now().After(choice.Deadline) {
+			choice = nil
+		}
 
 		gt.Scan(&GT_Dev, &GT_Old)
 		// fmt.Println(GT_Dev.X, GT_Dev.Y, GT_Dev.S)
-		if GT_Old.X[0] == GT_Dev.X[0] && GT_Old.Y[0] == GT_Dev.Y[0] && GT_Old.S[0] == GT_Dev.S[0] {
-			// time.Sleep(20 * time.Millisecond)
-			continue
-		}
+		touching := GT_Dev.TouchpointFlag > 0 && GT_Dev.TouchCount > 0
+		GT_Dev.TouchpointFlag = 0
 
-		if GT_Dev.TouchpointFlag > 0 {
-			GT_Dev.TouchpointFlag = 0
+		switch gestures.Update(touching, time.Now()) {
+		case tap:
 			fmt.Println(GT_Dev)
+			if choice != nil {
+				choice.Reply <- choiceRow(GT_Dev.X[0], choice.Options)
+				choice = nil
+				continue
+			}
+			// the api only listens for an undo right after adding a track
+			select {
+			case commChannels.Undo <- true:
+			default:
+				commChannels.StartRecording(recordDuration)
+			}
+		case longTap:
+			// only the result screen has something to save, ignore it otherwise
+			select {
+			case commChannels.Favorite <- true:
+			default:
+			}
 		}
 
-		// var input string
-		// fmt.Print("Enter 'p' for play or 'r' for record: ")
-		// _, err := fmt.Scan(&input)
-		// if err != nil {
-		// 	fmt.Println("Error reading input:", err)
-		// 	continue
-		// }
-		// switch input {
-		// case "p":
-		// 	commChannels.PlayChannel <- true
-		// case "r":
-		// 	commChannels.DisplayRecord <- true
-		// 	commChannels.RecordChannel <- time.Second * 5
-		// case "q":
-		// 	os.Exit(0)
-		// }
-
-		// if input == "p" || input == "r" || input == "s" {
-		// 	fmt.Println("Valid input:", input)
-		// } else {
-		// 	fmt.Println("Invalid input. Please try again.")
-		// }
-
+		if !touching {
+			time.Sleep(20 * time.Millisecond)
+		}
 	}
 }
 
-func Commands(commChannels *structs.CommChannels) *gobot.Robot {
+// Commands is the touch screen robot, a tap records for recordDuration or
+// undoes the track just added and a long press saves the result on screen to
+// the favorites
+func Commands(commChannels *structs.CommChannels, recordDuration time.Duration) *gobot.Robot {
 	work := func() {
-		run(commChannels)
+		run(commChannels, recordDuration)
 	}
 
 	robot := gobot.NewRobot("commands",
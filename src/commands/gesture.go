@@ -0,0 +1,53 @@
+package commands
+
+import "time"
+
+const (
+	// longPress is how long a finger stays down for a long press
+	longPress = 800 * time.Millisecond
+	// releaseAfter is how long the controller stays quiet once the finger is up,
+	// it reports the touch points about every 10ms while the finger is down
+	releaseAfter = 150 * time.Millisecond
+)
+
+type gesture int
+
+const (
+	noGesture gesture = iota
+	tap
+	longTap
+)
+
+// gestureDetector tells taps from long presses using the reports of the touch controller
+type gestureDetector struct {
+	down  bool
+	fired bool
+	start time.Time
+	last  time.Time
+}
+
+// Update is called after every scan, touching tells whether the controller
+// reported a touch point. A long press is recognized while the finger is
+// still down, a tap once it is released
+func (g *gestureDetector) Update(touching bool, now time.Time) gesture {
+	if touching {
+		if !g.down {
+			g.down, g.fired, g.start = true, false, now
+		}
+		g.last = now
+		if !g.fired && now.Sub(g.start) >= longPress {
+			g.fired = true
+			return longTap
+		}
+		return noGesture
+	}
+
+	if !g.down || now.Sub(g.last) < releaseAfter {
+		return noGesture
+	}
+	g.down = false
+	if g.fired {
+		return noGesture
+	}
+	return tap
+}
@@ -32,20 +32,30 @@ func (gt *Development) Init() {
 	gt.S = [5]int{0, 1, 2, 3, 4}
 }
 
+// bus is the part of the I2C connection used to talk to the touch controller
+type bus interface {
+	WriteBytes(buf []byte) (int, error)
+	ReadBytes(buf []byte) (int, error)
+	Close() error
+}
+
 type GT1151 struct {
-	i2c  *i2c.I2C
+	i2c  bus
 	TRST io.WriteablePin
 	INT  io.ReadablePin
 }
 
 func (gt *GT1151) New() {
-	// Create new connection to I2C bus on 2 line with address 0x27
-
-	i2c, err := i2c.NewI2C(Address, 1)
-	if err != nil {
-		log.Fatal(err)
+	if io.Simulated() {
+		gt.i2c = NewVirtualGT1151()
+	} else {
+		// Create new connection to I2C bus on 2 line with address 0x27
+		i2c, err := i2c.NewI2C(Address, 1)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gt.i2c = i2c
 	}
-	gt.i2c = i2c
 	gt.TRST = io.GetWritePin(io.TRST_PIN)
 	gt.INT = io.GetReadPin(io.INT_PIN)
 
@@ -67,14 +77,14 @@ func (gt *GT1151) Reset() {
 }
 
 func (gt *GT1151) WriteData(Reg, Data int) {
-	err := gt.i2c.WriteRegU8(uint8((Reg>>8)&0xFF), uint8((Reg&0xFF)|((Data&0xFF)<<8)))
+	_, err := gt.i2c.WriteBytes([]byte{uint8((Reg >> 8) & 0xFF), uint8(Reg & 0xFF), uint8(Data & 0xFF)})
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func (gt *GT1151) Write(Reg int) {
-	err := gt.i2c.WriteRegU8(uint8((Reg>>8)&0xFF), uint8(Reg&0xFF))
+	_, err := gt.i2c.WriteBytes([]byte{uint8((Reg >> 8) & 0xFF), uint8(Reg & 0xFF)})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -101,14 +111,13 @@ func (gt *GT1151) Scan(Dev, Old *Development) {
 	buf := make([]int, 0)
 	mask := 0x00
 
-	// if rpio.Pin(io.INT_PIN).Read() == 0 {
-	// 	Dev.Touch = 1
-	// } else {
-	// 	Dev.Touch = 0
-	// }
-	fmt.Println(gt.INT.Read())
-	// if Dev.Touch == 1 {
-	if false {
+	if gt.INT.Read() == 0 {
+		Dev.Touch = 1
+	} else {
+		Dev.Touch = 0
+	}
+
+	if Dev.Touch == 1 {
 		Dev.Touch = 0
 		buf = gt.Read(0x814E, 1)
 		if buf[0]&0x80 == 0x00 {
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"shazammini/src/io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type touchPoint struct {
+	X int
+	Y int
+	S int
+}
+
+// VirtualGT1151 emulates the registers of the GT1151 touch controller used by
+// GT1151.Scan. Touches are queued with Touch and signalled on the INT pin
+type VirtualGT1151 struct {
+	lock     sync.Mutex
+	reg      int
+	pending  []touchPoint
+	pressure int
+	INT      io.WriteablePin
+}
+
+func NewVirtualGT1151() *VirtualGT1151 {
+	return &VirtualGT1151{INT: io.GetWritePin(io.INT_PIN)}
+}
+
+// Touch queues a single finger touch at x, y
+func (v *VirtualGT1151) Touch(x, y int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// vary the pressure so that two touches on the same spot are not debounced
+	v.pressure = v.pressure%200 + 1
+	v.pending = append(v.pending, touchPoint{X: x, Y: y, S: v.pressure})
+	v.INT.Low()
+}
+
+// Hold keeps a finger down at x, y for d, the controller reports it again every 10ms
+func (v *VirtualGT1151) Hold(x, y int, d time.Duration) {
+	for end := time.Now().Add(d); time.Now().Before(end); {
+		v.Touch(x, y)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (v *VirtualGT1151) WriteBytes(buf []byte) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if len(buf) < 2 {
+		return 0, fmt.Errorf("register address needs 2 bytes, got %d", len(buf))
+	}
+	v.reg = int(buf[0])<<8 | int(buf[1])
+
+	// writing the status register acknowledges the current touch
+	if len(buf) > 2 && v.reg == 0x814E && len(v.pending) > 0 {
+		v.pending = v.pending[1:]
+		if len(v.pending) == 0 {
+			v.INT.High()
+		}
+	}
+	return len(buf), nil
+}
+
+func (v *VirtualGT1151) ReadBytes(buf []byte) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	switch v.reg {
+	case 0x8140:
+		copy(buf, "1158")
+	case 0x814E:
+		if len(v.pending) > 0 && len(buf) > 0 {
+			buf[0] = 0x80 | 1
+		}
+	case 0x814F:
+		if len(v.pending) > 0 && len(buf) >= 8 {
+			p := v.pending[0]
+			buf[1], buf[2] = byte(p.X), byte(p.X>>8)
+			buf[3], buf[4] = byte(p.Y), byte(p.Y>>8)
+			buf[5], buf[6] = byte(p.S), byte(p.S>>8)
+		}
+	}
+	return len(buf), nil
+}
+
+func (v *VirtualGT1151) Close() error {
+	return nil
+}
+
+// readTouches turns lines typed on stdin into touches on the virtual panel:
+// an empty line or "t" touches the centre, "t X Y" touches X, Y, "l" presses
+// the centre for a second, "l X Y" presses X, Y and "q" quits
+func readTouches(v *VirtualGT1151) {
+	fmt.Println("Simulator: press enter to touch the screen, 't X Y' to touch at X, Y, 'l [X Y]' for a long press, 'q' to quit")
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			v.Touch(EPD_CENTER_X, EPD_CENTER_Y)
+			continue
+		}
+
+		switch fields[0] {
+		case "q":
+			os.Exit(0)
+		case "t", "l":
+			x, y := EPD_CENTER_X, EPD_CENTER_Y
+			if len(fields) == 3 {
+				var errX, errY error
+				x, errX = strconv.Atoi(fields[1])
+				y, errY = strconv.Atoi(fields[2])
+				if errX != nil || errY != nil {
+					fmt.Printf("Usage: %s X Y\n", fields[0])
+					continue
+				}
+			}
+			if fields[0] == "l" {
+				v.Hold(x, y, time.Second)
+			} else {
+				v.Touch(x, y)
+			}
+		default:
+			fmt.Println("Unknown command:", fields[0])
+		}
+	}
+}
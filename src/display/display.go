@@ -1,14 +1,17 @@
 package display
 
 import (
+	"fmt"
 	"image/color"
 	"log"
 	"net"
 	"shazammini/src/io"
 	"shazammini/src/structs"
+	"shazammini/src/utils"
 	"time"
 
 	"github.com/fogleman/gg"
+	qrcode "github.com/skip2/go-qrcode"
 	"github.com/stianeikeland/go-rpio/v4"
 	wifiname "github.com/yelinaung/wifi-name"
 	"gobot.io/x/gobot"
@@ -23,11 +26,18 @@ type Display struct {
 	height    float64
 	assets    Assets
 	connected bool
+	panel     *VirtualPanel
 }
 
 func (d *Display) Initialise() {
 
-	d.epd = New(io.GetWritePin(io.RST_PIN), io.GetWritePin(io.DC_PIN), io.GetWritePin(io.CS_PIN), io.GetReadPin(io.BUSY_PIN), rpio.SpiTransmit)
+	transmit := Transmit(rpio.SpiTransmit)
+	if io.Simulated() {
+		d.panel = NewVirtualPanel("temp/panel.png")
+		transmit = d.panel.Transmit
+	}
+
+	d.epd = New(io.GetWritePin(io.RST_PIN), io.GetWritePin(io.DC_PIN), io.GetWritePin(io.CS_PIN), io.GetReadPin(io.BUSY_PIN), transmit)
 	config := Config{Rotation: ROTATION_0}
 	d.epd.Configure(config)
 	d.width = float64(d.epd.height)
@@ -41,7 +51,7 @@ func (d *Display) Initialise() {
 }
 
 func (d *Display) Print(str string, font float64, c Coordonates) float64 {
-	if err := d.img.LoadFontFace("/home/pi/dev/static/Inter-Black.ttf", font); err != nil {
+	if err := d.img.LoadFontFace("static/Inter-Black.ttf", font); err != nil {
 		panic(err)
 	}
 
@@ -88,8 +98,8 @@ func (d *Display) CheckConnection() {
 		d.Print("Ethernet", 15, Coordonates{X: d.width, Y: 10, OX: 1, OY: 0.5})
 		d.DrawPNG(&d.assets.WifiOn)
 		d.connected = true
-	} else if Connected() {
-		d.Print(wifiname.WifiName(), 15, Coordonates{X: d.width - 25, Y: 10, OX: 1, OY: 0.5})
+	} else if utils.Connected() {
+		d.Print(networkName(), 15, Coordonates{X: d.width - 25, Y: 10, OX: 1, OY: 0.5})
 		d.DrawPNG(&d.assets.WifiOn)
 		d.connected = true
 	} else {
@@ -99,6 +109,14 @@ func (d *Display) CheckConnection() {
 	}
 }
 
+// networkName returns the SSID shown in the corner of the screen
+func networkName() string {
+	if io.Simulated() {
+		return "Simulator"
+	}
+	return wifiname.WifiName()
+}
+
 func (d *Display) DrawWithDecoration() {
 	d.CheckConnection()
 	d.Version()
@@ -128,13 +146,83 @@ func (d *Display) Thinking() {
 	d.DrawWithDecoration()
 }
 
-func (d *Display) Result(trackName, artistName string) {
+func (d *Display) Message(text string) {
+	d.Clear()
+	d.Print(text, 25, Coordonates{X: d.width / 2, Y: d.height / 2, OX: 0.5, OY: 0.5})
+	d.DrawWithDecoration()
+}
+
+func (d *Display) Result(trackName, artistName, footer string) {
 	d.Clear()
 	offset := d.Print(trackName, 30, Coordonates{X: d.width / 2, Y: 40, OX: 0.5, OY: 0.5})
 	d.Print(artistName, 25, Coordonates{X: d.width / 2, Y: (40) + offset, OX: 0.5, OY: 1})
+	d.Print(footer, 15, Coordonates{X: 10, Y: d.height - 10, OX: 0, OY: 0.5})
+	d.DrawWithDecoration()
+}
+
+// Choice lists the options in rows, one per tap zone of the touch screen
+func (d *Display) Choice(options []string) {
+	d.Clear()
+	row := d.height / float64(len(options))
+	for i, option := range options {
+		if i > 0 {
+			d.img.SetColor(color.Black)
+			d.img.SetLineWidth(2)
+			d.img.DrawLine(0, float64(i)*row, d.width, float64(i)*row)
+			d.img.Stroke()
+		}
+		d.printLine(option, 15, Coordonates{X: 5, Y: float64(i)*row + row/2, OX: 0, OY: 0.5})
+	}
+	d.epd.Draw(d.img)
+}
+
+// printLine prints str on a single line, cut to the width of the screen
+func (d *Display) printLine(str string, font float64, c Coordonates) {
+	if err := d.img.LoadFontFace("static/Inter-Black.ttf", font); err != nil {
+		panic(err)
+	}
+
+	d.img.SetColor(color.Black)
+	runes := []rune(str)
+	for n := len(runes); n > 0; n-- {
+		line := string(runes[:n])
+		if n < len(runes) {
+			line += "…"
+		}
+		if w, _ := d.img.MeasureString(line); c.X+w <= d.width-5 {
+			d.img.DrawStringAnchored(line, c.X, c.Y, c.OX, c.OY)
+			return
+		}
+	}
+}
+
+// Login shows a QR code of the page to open on a phone to log in to Spotify
+func (d *Display) Login(url string) {
+	d.Clear()
+	code, err := qrcode.New(url, qrcode.Low)
+	if err != nil {
+		log.Println("Could not encode login address:", err)
+		d.Message("Log in to Spotify at " + url)
+		return
+	}
+	code.DisableBorder = true
+	size := int(d.height) - 30
+	d.img.DrawImageAnchored(code.Image(size), size/2+5, int(d.height)/2+8, 0.5, 0.5)
+
+	for i, line := range []string{"Scan to log", "in to Spotify"} {
+		d.Print(line, 18, Coordonates{X: float64(size) + 15, Y: 50 + float64(i)*22, OX: 0, OY: 0.5})
+	}
 	d.DrawWithDecoration()
 }
 
+// confidence tells how many windows of the recording agreed on the track
+func confidence(rec structs.Recognition) string {
+	if rec.Windows < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d agree", rec.Votes, rec.Windows)
+}
+
 func (d *Display) TryConnect() {
 	d.Clear()
 	d.Print("Looking for WiFi", 30, Coordonates{X: d.width / 2, Y: d.height / 2, OX: 0.5, OY: 0.5})
@@ -144,8 +232,6 @@ func (d *Display) TryConnect() {
 
 func run(commChannels *structs.CommChannels) {
 
-	defer rpio.Close()
-
 	display := Display{}
 
 	display.Initialise()
@@ -153,21 +239,32 @@ func run(commChannels *structs.CommChannels) {
 
 	display.loadAssets()
 
+	// the idle screen is only drawn once so that results stay on screen
+	idle := true
 	for {
 		if display.connected {
-			display.Idle()
+			if idle {
+				display.Idle()
+				idle = false
+			}
 			select {
 			case <-commChannels.DisplayRecord:
 				display.Recording()
 			case <-commChannels.DisplayThinking:
 				display.Thinking()
-			case track := <-commChannels.DisplayResult:
-				log.Println(track.Artists)
+			case text := <-commChannels.DisplayMessage:
+				display.Message(text)
+			case url := <-commChannels.DisplayLogin:
+				display.Login(url)
+			case options := <-commChannels.DisplayChoice:
+				display.Choice(options)
+			case rec := <-commChannels.DisplayResult:
+				log.Println(rec.Track.Artists)
 				// artist := "Unknown"
 				// if len(track.Artists) > 1 {
 				// 	artist = track.Artists[0].Name
 				// }
-				display.Result(track.Title, track.Subtitle)
+				display.Result(rec.Track.Title, rec.Track.Subtitle, confidence(rec))
 
 				// case <-time.After(5 * time.Second):
 				// 	display.CheckConnection()
@@ -175,6 +272,7 @@ func run(commChannels *structs.CommChannels) {
 		} else {
 			display.TryConnect()
 			time.Sleep(5 * time.Second)
+			idle = true
 		}
 	}
 }
@@ -0,0 +1,112 @@
+package display
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"shazammini/src/io"
+)
+
+// VirtualPanel emulates the controller of the 2.13" panel. It decodes the
+// command/data stream sent by the EPD driver into a framebuffer and saves
+// it as a PNG every time the driver activates the display
+type VirtualPanel struct {
+	dc      io.ReadablePin
+	path    string
+	command byte
+	args    []byte
+	x       int
+	y       int
+	stride  int
+	height  int
+	ram     []byte
+}
+
+// NewVirtualPanel creates a panel rendering into the PNG file at path
+func NewVirtualPanel(path string) *VirtualPanel {
+	stride := (EPD_WIDTH + 7) / 8
+	p := &VirtualPanel{
+		dc:     io.GetReadPin(io.DC_PIN),
+		path:   path,
+		stride: stride,
+		height: EPD_HEIGHT,
+		ram:    make([]byte, stride*EPD_HEIGHT),
+	}
+	for i := range p.ram {
+		p.ram[i] = 0xFF
+	}
+	return p
+}
+
+// Transmit has the same signature as the SPI transmitter so it can be handed to the EPD driver
+func (p *VirtualPanel) Transmit(data ...byte) {
+	for _, b := range data {
+		if p.dc.Read() == 0 {
+			p.sendCommand(b)
+		} else {
+			p.sendData(b)
+		}
+	}
+}
+
+func (p *VirtualPanel) sendCommand(c byte) {
+	p.command = c
+	p.args = p.args[:0]
+
+	if c == MASTER_ACTIVATION {
+		if err := p.Save(); err != nil {
+			log.Println("Could not save virtual panel:", err)
+		}
+	}
+}
+
+func (p *VirtualPanel) sendData(d byte) {
+	switch p.command {
+	case SET_RAM_X_ADDRESS_COUNTER:
+		p.x = int(d)
+	case SET_RAM_Y_ADDRESS_COUNTER:
+		p.args = append(p.args, d)
+		p.y = int(p.args[0])
+		if len(p.args) > 1 {
+			p.y |= int(p.args[1]) << 8
+		}
+	case WRITE_RAM:
+		if p.x >= p.stride {
+			p.x = 0
+			p.y++
+		}
+		if p.y < p.height {
+			p.ram[p.y*p.stride+p.x] = d
+		}
+		p.x++
+	}
+}
+
+// Image returns the framebuffer as the user sees it, in landscape
+func (p *VirtualPanel) Image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, p.height, EPD_WIDTH))
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			c := color.Gray{Y: 0xFF}
+			if p.ram[y*p.stride+x/8]&(0x80>>(x%8)) == 0 {
+				c = color.Gray{Y: 0x00}
+			}
+			// the drawing context is rotated by 90 degrees, turn it back
+			img.SetGray(p.height-1-y, x, c)
+		}
+	}
+	return img
+}
+
+// Save writes the framebuffer to the panel's PNG file
+func (p *VirtualPanel) Save() error {
+	f, err := os.Create(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, p.Image())
+}
@@ -0,0 +1,196 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"shazammini/src/history"
+	"strconv"
+	"time"
+)
+
+// Formats are the names of the formats the history can be exported to
+var Formats = []string{"csv", "jsonl", "m3u", "xspf"}
+
+var contentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/jsonl; charset=utf-8",
+	"m3u":   "audio/x-mpegurl; charset=utf-8",
+	"xspf":  "application/xspf+xml; charset=utf-8",
+}
+
+// ContentType is the media type of the format, empty for unknown formats
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Write exports the entries in the format
+func Write(w io.Writer, format string, entries []history.Entry) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, entries)
+	case "jsonl":
+		return writeJSONL(w, entries)
+	case "m3u":
+		return writeM3U(w, entries)
+	case "xspf":
+		return writeXSPF(w, entries)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// ParseRange reads the first and last days to export, YYYY-MM-DD in
+// location, both included. Days left empty leave the range open
+func ParseRange(from, to string, location *time.Location) (history.Query, error) {
+	q := history.Query{}
+	if from != "" {
+		day, err := time.ParseInLocation("2006-01-02", from, location)
+		if err != nil {
+			return q, fmt.Errorf("invalid first day %q, expected YYYY-MM-DD", from)
+		}
+		q.From = day
+	}
+	if to != "" {
+		day, err := time.ParseInLocation("2006-01-02", to, location)
+		if err != nil {
+			return q, fmt.Errorf("invalid last day %q, expected YYYY-MM-DD", to)
+		}
+		q.To = day.AddDate(0, 0, 1)
+	}
+	return q, nil
+}
+
+// location is where a player finds the track, the Spotify track when it was
+// found, otherwise the Shazam page
+func location(e history.Entry) string {
+	if e.Recognition.SpotifyURI != "" {
+		return e.Recognition.SpotifyURI
+	}
+	if e.Recognition.Track.Url != "" {
+		return e.Recognition.Track.Url
+	}
+	return e.Recognition.Track.Share.Href
+}
+
+func writeCSV(w io.Writer, entries []history.Entry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"recorded_at", "title", "artist", "genre", "isrc", "outcome", "error", "spotify_uri", "shazam_url", "share_url", "confidence", "backend"})
+	for _, e := range entries {
+		rec := e.Recognition
+		out.Write([]string{
+			e.RecordedAt.Format(time.RFC3339),
+			rec.Track.Title,
+			rec.Track.Subtitle,
+			rec.Track.Genre.Primary,
+			rec.Track.Isrc,
+			e.Outcome,
+			e.Error,
+			rec.SpotifyURI,
+			rec.Track.Url,
+			rec.Track.Share.Href,
+			strconv.FormatFloat(rec.Confidence, 'f', 2, 64),
+			rec.Backend,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeJSONL(w io.Writer, entries []history.Entry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeM3U writes an extended M3U playlist, the Shazam pages of tracks found
+// on Spotify are given in comments since an entry has a single location
+func writeM3U(w io.Writer, entries []history.Entry) error {
+	if _, err := fmt.Fprint(w, "#EXTM3U\n"); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		loc := location(e)
+		if loc == "" {
+			continue
+		}
+		track := e.Recognition.Track
+		fmt.Fprintf(w, "#EXTINF:-1,%s - %s\n", track.Subtitle, track.Title)
+		for _, shazam := range []string{track.Url, track.Share.Href} {
+			if shazam != "" && shazam != loc {
+				fmt.Fprintf(w, "# Shazam: %s\n", shazam)
+			}
+		}
+		if _, err := fmt.Fprintln(w, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Date    string      `xml:"date,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Locations  []string `xml:"location"`
+	Identifier string   `xml:"identifier,omitempty"`
+	Title      string   `xml:"title"`
+	Creator    string   `xml:"creator"`
+	Annotation string   `xml:"annotation,omitempty"`
+	Info       string   `xml:"info,omitempty"`
+	Image      string   `xml:"image,omitempty"`
+}
+
+// writeXSPF writes an XSPF playlist, every track lists its Spotify track and
+// its Shazam pages as alternative locations
+func writeXSPF(w io.Writer, entries []history.Entry) error {
+	playlist := xspfPlaylist{
+		Version: "1",
+		XMLNS:   "http://xspf.org/ns/0/",
+		Title:   "ShazPi",
+		Date:    time.Now().Format(time.RFC3339),
+	}
+	for _, e := range entries {
+		rec := e.Recognition
+		track := xspfTrack{
+			Title:      rec.Track.Title,
+			Creator:    rec.Track.Subtitle,
+			Annotation: "Recorded " + e.RecordedAt.Format("2006-01-02 15:04") + ", " + e.Outcome,
+			Info:       rec.Track.Share.Href,
+			Image:      rec.Track.Image.CoverArt,
+		}
+		seen := map[string]bool{"": true}
+		for _, loc := range []string{rec.SpotifyURI, rec.Track.Url, rec.Track.Share.Href} {
+			if !seen[loc] {
+				seen[loc] = true
+				track.Locations = append(track.Locations, loc)
+			}
+		}
+		if rec.Track.Isrc != "" {
+			track.Identifier = "urn:isrc:" + rec.Track.Isrc
+		}
+		playlist.Tracks = append(playlist.Tracks, track)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
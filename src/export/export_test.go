@@ -0,0 +1,166 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"shazammini/src/history"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestParseRange(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  bool
+	}{
+		{"open", "", "", time.Time{}, time.Time{}, false},
+		{"both days", "2026-10-01", "2026-10-31",
+			time.Date(2026, 10, 1, 0, 0, 0, 0, paris), time.Date(2026, 11, 1, 0, 0, 0, 0, paris), false},
+		{"single day", "2026-10-17", "2026-10-17",
+			time.Date(2026, 10, 17, 0, 0, 0, 0, paris), time.Date(2026, 10, 18, 0, 0, 0, 0, paris), false},
+		{"last day of the year", "", "2026-12-31", time.Time{}, time.Date(2027, 1, 1, 0, 0, 0, 0, paris), false},
+		{"invalid first day", "01/10/2026", "", time.Time{}, time.Time{}, true},
+		{"invalid last day", "", "2026-02-30", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseRange(tt.from, tt.to, paris)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if !q.From.Equal(tt.wantFrom) || !q.To.Equal(tt.wantTo) {
+				t.Errorf("got %s to %s, want %s to %s", q.From, q.To, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+// TestParseRangeIncludesLastDay runs the query of a range against a history
+// holding songs recorded around midnight of the last day
+func TestParseRangeIncludesLastDay(t *testing.T) {
+	s, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, at := range []time.Time{
+		time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC),
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if _, err := s.Add(history.Entry{RecordedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := ParseRange("2026-10-01", "2026-10-31", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := s.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+		t.Errorf("exported %+v, want the entries of October", entries)
+	}
+}
+
+func entries() []history.Entry {
+	recorded := time.Date(2026, 10, 17, 21, 4, 5, 0, time.FixedZone("CEST", 2*60*60))
+	lucky := structs.Recognition{
+		Track: structs.Track{
+			Title:    "Get Lucky",
+			Subtitle: "Daft Punk Featuring Pharrell Williams",
+			Isrc:     "USQX91300108",
+			Url:      "https://www.shazam.com/track/65000000/get-lucky",
+			Genre:    structs.Genre{Primary: "Dance"},
+			Image:    structs.TrackImages{CoverArt: "https://images.example/get-lucky.jpg"},
+		},
+		SpotifyURI: "spotify:track:69kOkLUCkxIZYexIgSG8rq",
+		Confidence: 0.92,
+		Backend:    "shazam",
+	}
+	lucky.Track.Share.Href = "https://www.shazam.com/track/65000000"
+	unmatched := structs.Recognition{
+		Track: structs.Track{
+			Title:    `Song "with", quotes & <tags>`,
+			Subtitle: "Band",
+			Url:      "https://www.shazam.com/track/1/song",
+		},
+		Confidence: 0.5,
+		Backend:    "local",
+	}
+
+	return []history.Entry{
+		{ID: 1, Recognition: lucky, RecordedAt: recorded, Outcome: history.Added},
+		{ID: 2, Recognition: unmatched, RecordedAt: recorded.Add(time.Hour), Outcome: history.Failed, Error: "no Spotify track found for Song by Band"},
+		// nothing to point a playlist to
+		{ID: 3, Recognition: structs.Recognition{Track: structs.Track{Title: "Unknown"}}, RecordedAt: recorded.Add(2 * time.Hour), Outcome: history.Skipped},
+	}
+}
+
+// xspfDate is the date the playlist was written, which changes every run
+var xspfDate = regexp.MustCompile(`<date>[^<]*</date>`)
+
+func TestWriteGolden(t *testing.T) {
+	for _, format := range []string{"csv", "m3u", "xspf"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, entries()); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			if format == "xspf" {
+				if !xspfDate.Match(got) {
+					t.Error("the playlist has no date")
+				}
+				got = xspfDate.ReplaceAll(got, []byte("<date>DATE</date>"))
+			}
+
+			golden := filepath.Join("testdata", "history."+format+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, "pdf", entries()); err == nil {
+		t.Error("wrote an unknown format")
+	}
+	if ContentType("pdf") != "" {
+		t.Error("unknown format has a content type")
+	}
+	for _, format := range Formats {
+		if ContentType(format) == "" {
+			t.Errorf("%s has no content type", format)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package export
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"shazammini/src/history"
+	"time"
+)
+
+// Handler exports the history over HTTP, such as
+// /export?format=csv&from=2026-10-01&to=2026-10-31. The format is CSV by default
+func Handler(store *history.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		format := params.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if ContentType(format) == "" {
+			http.Error(w, fmt.Sprintf("unknown format %q, expected one of %v", format, Formats), http.StatusBadRequest)
+			return
+		}
+		q, err := ParseRange(params.Get("from"), params.Get("to"), time.Local)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := store.Query(q)
+		if err != nil {
+			log.Println("Could not read history:", err)
+			http.Error(w, "could not read history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="shazpi.%s"`, format))
+		if err := Write(w, format, entries); err != nil {
+			log.Println("Could not export history:", err)
+		}
+	})
+}
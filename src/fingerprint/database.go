@@ -0,0 +1,159 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"shazammini/src/audio"
+	"shazammini/src/utils"
+	"strings"
+)
+
+// Song is a song of the library
+type Song struct {
+	ID     uint32
+	Title  string
+	Artist string
+	Path   string
+}
+
+// Posting is an occurrence of a hash in a song
+type Posting struct {
+	Song  uint32
+	Frame uint32
+}
+
+// Database is the index of the landmarks of a music library
+type Database struct {
+	Songs []Song
+	Index map[uint32][]Posting
+}
+
+// Match is the song of the database that best matches a clip
+type Match struct {
+	Song Song
+	// Score is the number of landmarks aligned on the same offset
+	Score int
+	// Offset is where the clip starts in the song
+	Offset float64
+	// Confidence compares the score to the score of the runner-up, between 0 and 1
+	Confidence float64
+}
+
+func New() *Database {
+	return &Database{Index: map[uint32][]Posting{}}
+}
+
+// Load reads a database saved with Save
+func Load(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := New()
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Save writes the database to path
+func (db *Database) Save(path string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(db); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// Add indexes the landmarks of a song
+func (db *Database) Add(song Song, clip audio.Clip) Song {
+	song.ID = uint32(len(db.Songs))
+	db.Songs = append(db.Songs, song)
+
+	for _, landmark := range Fingerprint(clip) {
+		db.Index[landmark.Hash] = append(db.Index[landmark.Hash], Posting{Song: song.ID, Frame: landmark.Frame})
+	}
+	return song
+}
+
+// AddDir indexes every WAV file found under dir. Files named "Artist - Title.wav"
+// get their metadata from their name, others are titled after the file.
+// progress is called for every file, with the error that prevented indexing it if any
+func (db *Database) AddDir(dir string, progress func(path string, err error)) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".wav") {
+			return nil
+		}
+
+		clip, err := audio.ReadWAV(path)
+		if err == nil {
+			db.Add(songFromPath(path), clip)
+		}
+		progress(path, err)
+		return nil
+	})
+}
+
+func songFromPath(path string) Song {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	song := Song{Title: name, Path: path}
+	if artist, title, found := strings.Cut(name, " - "); found {
+		song.Artist = strings.TrimSpace(artist)
+		song.Title = strings.TrimSpace(title)
+	}
+	return song
+}
+
+// Match looks for the song the clip was recorded from. It returns false when
+// no song has at least minScore landmarks aligned with the clip
+func (db *Database) Match(clip audio.Clip, minScore int) (Match, bool) {
+	type candidate struct {
+		song   uint32
+		offset int
+	}
+
+	// landmarks of the right song all appear at the same offset
+	counts := map[candidate]int{}
+	for _, landmark := range Fingerprint(clip) {
+		for _, posting := range db.Index[landmark.Hash] {
+			counts[candidate{song: posting.Song, offset: int(posting.Frame) - int(landmark.Frame)}]++
+		}
+	}
+
+	// keep the best offset of every song
+	bestPerSong := map[uint32]candidate{}
+	for c, count := range counts {
+		if previous, ok := bestPerSong[c.song]; !ok || count > counts[previous] {
+			bestPerSong[c.song] = c
+		}
+	}
+
+	var best, second int
+	var winner candidate
+	for _, c := range bestPerSong {
+		count := counts[c]
+		if count > best {
+			second = best
+			best, winner = count, c
+		} else if count > second {
+			second = count
+		}
+	}
+
+	if best < minScore || best == 0 {
+		return Match{}, false
+	}
+
+	return Match{
+		Song:       db.Songs[winner.song],
+		Score:      best,
+		Offset:     float64(winner.offset) * frameDuration,
+		Confidence: float64(best-second) / float64(best),
+	}, true
+}
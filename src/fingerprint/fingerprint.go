@@ -0,0 +1,124 @@
+// Package fingerprint identifies songs with landmark (constellation) fingerprints:
+// the strongest peaks of the spectrogram are paired into hashes made of both
+// frequencies and the time between them, which survive noise and are looked up
+// in an index of known songs.
+package fingerprint
+
+import (
+	"math"
+	"shazammini/src/audio"
+)
+
+const (
+	// SampleRate is the rate clips are resampled to before fingerprinting
+	SampleRate = 11025
+	frameSize  = 1024
+	frameHop   = 256
+	// frameDuration is the time between two spectrogram frames, in seconds
+	frameDuration = float64(frameHop) / SampleRate
+
+	// a target zone starts right after its anchor peak and spans maxDelta frames
+	maxDelta = 63
+	fanOut   = 5
+)
+
+// bands split the spectrum, one peak is kept per band and frame
+var bands = []int{10, 20, 40, 80, 160, 512}
+
+// peakThreshold is how much louder than the average band maximum a peak must be
+const peakThreshold = 1.05
+
+type peak struct {
+	frame int
+	bin   int
+}
+
+// Landmark is a hash of a pair of peaks and the frame of its anchor
+type Landmark struct {
+	Hash  uint32
+	Frame uint32
+}
+
+// spectrogram returns the log magnitude of the spectrum of every frame
+func spectrogram(clip audio.Clip) [][]float64 {
+	samples := clip.Mono().Resample(SampleRate).Samples
+	window := hann(frameSize)
+	buffer := make([]complex128, frameSize)
+
+	var frames [][]float64
+	for start := 0; start+frameSize <= len(samples); start += frameHop {
+		for i := range buffer {
+			buffer[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fft(buffer)
+
+		magnitudes := make([]float64, frameSize/2)
+		for i := range magnitudes {
+			re, im := real(buffer[i]), imag(buffer[i])
+			magnitudes[i] = math.Log1p(math.Sqrt(re*re + im*im))
+		}
+		frames = append(frames, magnitudes)
+	}
+	return frames
+}
+
+// peaks keeps the loudest bin of every band of every frame, when it stands
+// out from the rest of the frame
+func peaks(frames [][]float64) []peak {
+	var found []peak
+	for f, magnitudes := range frames {
+		candidates := make([]peak, 0, len(bands)-1)
+		sum := 0.0
+		for b := 0; b < len(bands)-1; b++ {
+			best := bands[b]
+			for bin := bands[b]; bin < bands[b+1]; bin++ {
+				if magnitudes[bin] > magnitudes[best] {
+					best = bin
+				}
+			}
+			candidates = append(candidates, peak{frame: f, bin: best})
+			sum += magnitudes[best]
+		}
+
+		mean := sum / float64(len(candidates))
+		if mean == 0 {
+			continue
+		}
+		for _, p := range candidates {
+			if magnitudes[p.bin] >= mean*peakThreshold {
+				found = append(found, p)
+			}
+		}
+	}
+	return found
+}
+
+func hash(anchor, target peak) uint32 {
+	delta := uint32(target.frame - anchor.frame)
+	return uint32(anchor.bin)<<15 | uint32(target.bin)<<6 | delta
+}
+
+// Fingerprint returns the landmarks of a clip
+func Fingerprint(clip audio.Clip) []Landmark {
+	found := peaks(spectrogram(clip))
+
+	var landmarks []Landmark
+	for i, anchor := range found {
+		paired := 0
+		for _, target := range found[i+1:] {
+			delta := target.frame - anchor.frame
+			if delta > maxDelta {
+				break
+			}
+			if delta < 1 {
+				continue
+			}
+			landmarks = append(landmarks, Landmark{Hash: hash(anchor, target), Frame: uint32(anchor.frame)})
+			paired++
+			if paired == fanOut {
+				break
+			}
+		}
+	}
+	return landmarks
+}
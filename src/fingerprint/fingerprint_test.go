@@ -0,0 +1,142 @@
+package fingerprint
+
+import (
+	"math"
+	"math/rand"
+	"shazammini/src/audio"
+	"testing"
+)
+
+// minScore is far above the landmarks that align by chance between the
+// random melodies, a few dozen, and far below those of an excerpt of the song
+const minScore = 100
+
+// melody is a song of random notes, the same for the same seed
+func melody(seed int64, seconds float64) audio.Clip {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]int16, int(seconds*SampleRate))
+	noteLength := SampleRate / 20
+
+	var low, high float64
+	for i := range samples {
+		if i%noteLength == 0 {
+			low = 200 + rng.Float64()*600
+			high = 1000 + rng.Float64()*3000
+		}
+		t := float64(i) / SampleRate
+		samples[i] = int16(8000*math.Sin(2*math.Pi*low*t) + 4000*math.Sin(2*math.Pi*high*t))
+	}
+	return audio.Clip{Samples: samples, SampleRate: SampleRate, Channels: 1}
+}
+
+// excerpt cuts seconds of the clip from start and adds noise to it
+func excerpt(clip audio.Clip, start, seconds, noise float64) audio.Clip {
+	from := int(start * float64(clip.SampleRate))
+	cut := append([]int16(nil), clip.Samples[from:from+int(seconds*float64(clip.SampleRate))]...)
+	rng := rand.New(rand.NewSource(99))
+	for i := range cut {
+		cut[i] += int16(rng.NormFloat64() * noise)
+	}
+	return audio.Clip{Samples: cut, SampleRate: clip.SampleRate, Channels: clip.Channels}
+}
+
+func library() (*Database, []audio.Clip) {
+	db := New()
+	var clips []audio.Clip
+	for i, title := range []string{"First", "Second", "Third"} {
+		clip := melody(int64(i+1), 30)
+		db.Add(Song{Title: title, Artist: "Band"}, clip)
+		clips = append(clips, clip)
+	}
+	return db, clips
+}
+
+func TestMatchNoisyExcerpt(t *testing.T) {
+	db, clips := library()
+
+	tests := []struct {
+		name  string
+		song  int
+		start float64
+	}{
+		{"from the start", 0, 0},
+		{"shifted", 1, 7.3},
+		{"between frames", 2, 12.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := db.Match(excerpt(clips[tt.song], tt.start, 5, 1500), minScore)
+			if !ok {
+				t.Fatal("no match")
+			}
+			if m.Song.ID != uint32(tt.song) || m.Song.Title != db.Songs[tt.song].Title {
+				t.Errorf("matched %+v, want %s", m.Song, db.Songs[tt.song].Title)
+			}
+			if math.Abs(m.Offset-tt.start) > 2*frameDuration {
+				t.Errorf("offset %.3fs, want %.3fs", m.Offset, tt.start)
+			}
+			if m.Confidence <= 0.5 {
+				t.Errorf("confidence %.2f with score %d", m.Confidence, m.Score)
+			}
+		})
+	}
+}
+
+func TestMatchUnknownClip(t *testing.T) {
+	db, _ := library()
+
+	if m, ok := db.Match(excerpt(melody(42, 10), 2, 5, 1500), minScore); ok {
+		t.Errorf("matched %s with score %d", m.Song.Title, m.Score)
+	}
+	if m, ok := db.Match(audio.Clip{Samples: make([]int16, 5*SampleRate), SampleRate: SampleRate, Channels: 1}, 0); ok {
+		t.Errorf("silence matched %s", m.Song.Title)
+	}
+}
+
+func TestMatchMinScore(t *testing.T) {
+	db, clips := library()
+	clip := excerpt(clips[0], 3, 5, 1500)
+
+	m, ok := db.Match(clip, 1)
+	if !ok {
+		t.Fatal("no match")
+	}
+	if _, ok := db.Match(clip, m.Score); !ok {
+		t.Errorf("rejected a score of %d with a minimum of %d", m.Score, m.Score)
+	}
+	if _, ok := db.Match(clip, m.Score+1); ok {
+		t.Errorf("accepted a score of %d with a minimum of %d", m.Score, m.Score+1)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	db, clips := library()
+	path := t.TempDir() + "/index.gob"
+	if err := db.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := loaded.Match(excerpt(clips[2], 4, 5, 1500), minScore)
+	if !ok || m.Song.Title != "Third" {
+		t.Errorf("loaded index matched %+v, %v", m.Song, ok)
+	}
+}
+
+func TestSongFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Song
+	}{
+		{"/music/Daft Punk - Get Lucky.wav", Song{Artist: "Daft Punk", Title: "Get Lucky", Path: "/music/Daft Punk - Get Lucky.wav"}},
+		{"/music/untitled.WAV", Song{Title: "untitled", Path: "/music/untitled.WAV"}},
+	}
+	for _, tt := range tests {
+		if got := songFromPath(tt.path); got != tt.want {
+			t.Errorf("songFromPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
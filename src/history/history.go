@@ -0,0 +1,249 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shazammini/src/structs"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// outcomes of adding a recognition to Spotify
+const (
+	Added     = "added"
+	Duplicate = "duplicate"
+	Skipped   = "skipped" // nothing picked in confirm mode
+	Failed    = "failed"
+	Removed   = "removed" // taken back out of the playlists with a tap
+)
+
+// statuses of the delivery of a recognition to a sink
+const (
+	Pending   = "pending"   // queued, or failed and waiting to be sent again
+	Delivered = "delivered" // accepted by the sink
+	Dropped   = "dropped"   // refused for good
+)
+
+var (
+	entriesBucket = []byte("entries")
+	metaBucket    = []byte("meta")
+)
+
+// ErrNotFound is returned for an entry that is not in the history
+var ErrNotFound = errors.New("not in history")
+
+// Entry is a recognition and what became of it
+type Entry struct {
+	ID           uint64              `json:"id"`
+	Recognition  structs.Recognition `json:"recognition"`
+	RecordedAt   time.Time           `json:"recorded_at"`
+	RecognizedAt time.Time           `json:"recognized_at"`
+	Clip         string              `json:"clip,omitempty"` // recording kept on disk, if any
+	Outcome      string              `json:"outcome"`
+	Error        string              `json:"error,omitempty"`
+	Sinks        map[string]Delivery `json:"sinks,omitempty"`
+}
+
+// Delivery is where a recognition stands with a sink
+type Delivery struct {
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Query selects entries, the zero value selects them all. Conditions left
+// empty do not filter
+type Query struct {
+	After   uint64    // entries added after this ID
+	From    time.Time // recorded at or after
+	To      time.Time // recorded before
+	Artist  string    // part of the artist name, case insensitive
+	Genre   string    // primary genre, case insensitive
+	Outcome string
+	Limit   int // most recent entries kept when there are more
+}
+
+// Store keeps the history in a bbolt database, every write is a transaction
+// synced to disk before it returns, so the history survives power cuts
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the history, creating it when needed
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open history %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{entriesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// key orders the entries in the order they were added
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Add saves a new entry and returns its ID
+func (s *Store) Add(e Entry) (uint64, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		e.Recognition.ID = id
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(id), data)
+	})
+	return e.ID, err
+}
+
+// Get returns the entry with the ID
+func (s *Store) Get(id uint64) (Entry, error) {
+	e := Entry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(entriesBucket).Get(key(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &e)
+	})
+	return e, err
+}
+
+// SetDelivery records where the entry stands with the sink
+func (s *Store) SetDelivery(id uint64, sink string, d Delivery) error {
+	return s.update(id, func(e *Entry) {
+		if e.Sinks == nil {
+			e.Sinks = map[string]Delivery{}
+		}
+		e.Sinks[sink] = d
+	})
+}
+
+// SetOutcome changes what became of the entry, such as when it is removed again
+func (s *Store) SetOutcome(id uint64, outcome, reason string) error {
+	return s.update(id, func(e *Entry) {
+		e.Outcome = outcome
+		e.Error = reason
+	})
+}
+
+// update changes the entry within a single transaction
+func (s *Store) update(id uint64, change func(e *Entry)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		data := b.Get(key(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		e := Entry{}
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		change(&e)
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(id), data)
+	})
+}
+
+// Query returns the entries matching q, oldest first
+func (s *Store) Query(q Query) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(entriesBucket).Cursor()
+		for k, data := c.Seek(key(q.After + 1)); k != nil; k, data = c.Next() {
+			e := Entry{}
+			if err := json.Unmarshal(data, &e); err != nil {
+				return fmt.Errorf("entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if q.matches(e) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, err
+}
+
+func (q Query) matches(e Entry) bool {
+	track := e.Recognition.Track
+	switch {
+	case !q.From.IsZero() && e.RecordedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !e.RecordedAt.Before(q.To):
+		return false
+	case q.Outcome != "" && e.Outcome != q.Outcome:
+		return false
+	case q.Genre != "" && !strings.EqualFold(track.Genre.Primary, q.Genre):
+		return false
+	case q.Artist != "" && !hasArtist(track, q.Artist):
+		return false
+	}
+	return true
+}
+
+func hasArtist(track structs.Track, artist string) bool {
+	artist = strings.ToLower(artist)
+	if strings.Contains(strings.ToLower(track.Subtitle), artist) {
+		return true
+	}
+	for _, a := range track.Artists {
+		if strings.Contains(strings.ToLower(a.Name), artist) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mark remembers a position in the history under name, such as the last
+// entry sent in a digest
+func (s *Store) Mark(name string, id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte(name), key(id))
+	})
+}
+
+// Marked returns the position remembered under name, 0 when there is none
+func (s *Store) Marked(name string) (uint64, error) {
+	var id uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k := tx.Bucket(metaBucket).Get([]byte(name)); len(k) == 8 {
+			id = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return id, err
+}
@@ -0,0 +1,172 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"shazammini/src/structs"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func entry(title, artist, genre, outcome string, recorded time.Time) Entry {
+	return Entry{
+		Recognition: structs.Recognition{Track: structs.Track{
+			Title:    title,
+			Subtitle: artist,
+			Genre:    structs.Genre{Primary: genre},
+		}},
+		RecordedAt: recorded,
+		Outcome:    outcome,
+	}
+}
+
+func titles(entries []Entry) []string {
+	var found []string
+	for _, e := range entries {
+		found = append(found, e.Recognition.Track.Title)
+	}
+	return found
+}
+
+func TestQuery(t *testing.T) {
+	s, _ := openStore(t)
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	featuring := entry("Get Lucky", "Daft Punk Featuring Pharrell Williams", "Dance", Added, day.Add(-time.Second))
+	featuring.Recognition.Track.Artists = []structs.Artist{{Name: "Daft Punk"}, {Name: "Pharrell Williams"}}
+	for _, e := range []Entry{
+		featuring,
+		entry("Midnight", "Start of Day", "Jazz", Added, day),
+		entry("Noon", "Band", "jazz", Duplicate, day.Add(12*time.Hour)),
+		entry("Evening", "Daft Punk", "Electronic", Failed, day.Add(24*time.Hour-time.Nanosecond)),
+		entry("Next Day", "Band", "Rock", Skipped, day.Add(24*time.Hour)),
+	} {
+		if _, err := s.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all, oldest first", Query{}, []string{"Get Lucky", "Midnight", "Noon", "Evening", "Next Day"}},
+		{"from is inclusive, to is not", Query{From: day, To: day.Add(24 * time.Hour)}, []string{"Midnight", "Noon", "Evening"}},
+		{"from only", Query{From: day.Add(12 * time.Hour)}, []string{"Noon", "Evening", "Next Day"}},
+		{"to only", Query{To: day}, []string{"Get Lucky"}},
+		{"after", Query{After: 3}, []string{"Evening", "Next Day"}},
+		{"after the last", Query{After: 5}, nil},
+		{"limit keeps the most recent", Query{Limit: 2}, []string{"Evening", "Next Day"}},
+		{"limit above the count", Query{Limit: 10}, []string{"Get Lucky", "Midnight", "Noon", "Evening", "Next Day"}},
+		{"artist in the subtitle", Query{Artist: "daft"}, []string{"Get Lucky", "Evening"}},
+		{"artist in the credits", Query{Artist: "PHARRELL"}, []string{"Get Lucky"}},
+		{"genre ignores case", Query{Genre: "JAZZ"}, []string{"Midnight", "Noon"}},
+		{"genre is not a substring", Query{Genre: "Jaz"}, nil},
+		{"outcome", Query{Outcome: Duplicate}, []string{"Noon"}},
+		{"combined", Query{From: day, Artist: "band", Limit: 1}, []string{"Next Day"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatesAreKept(t *testing.T) {
+	s, path := openStore(t)
+	recorded := time.Date(2026, 10, 17, 21, 4, 5, 0, time.UTC)
+	id, err := s.Add(entry("Song", "Band", "Pop", Added, recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := Delivery{Status: Delivered, At: recorded.Add(time.Minute)}
+	pending := Delivery{Status: Pending, Error: "connection refused", At: recorded.Add(time.Minute)}
+	if err := s.SetDelivery(id, "lastfm", pending); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDelivery(id, "webhook", delivered); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDelivery(id, "lastfm", delivered); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOutcome(id, Removed, "taken back"); err != nil {
+		t.Fatal(err)
+	}
+
+	// written to disk, not only kept in memory
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != id || e.Recognition.ID != id {
+		t.Errorf("entry %d holds recognition %d, want %d", e.ID, e.Recognition.ID, id)
+	}
+	if !e.RecordedAt.Equal(recorded) {
+		t.Errorf("recorded at %s, want %s", e.RecordedAt, recorded)
+	}
+	if e.Outcome != Removed || e.Error != "taken back" {
+		t.Errorf("outcome %q (%q)", e.Outcome, e.Error)
+	}
+	want := map[string]Delivery{"lastfm": delivered, "webhook": delivered}
+	if len(e.Sinks) != len(want) {
+		t.Fatalf("sinks %v, want %v", e.Sinks, want)
+	}
+	for sink, d := range want {
+		got := e.Sinks[sink]
+		if got.Status != d.Status || got.Error != d.Error || !got.At.Equal(d.At) {
+			t.Errorf("%s is %+v, want %+v", sink, got, d)
+		}
+	}
+}
+
+func TestUnknownEntry(t *testing.T) {
+	s, _ := openStore(t)
+	if _, err := s.Get(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get returned %v", err)
+	}
+	if err := s.SetOutcome(1, Added, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetOutcome returned %v", err)
+	}
+	if err := s.SetDelivery(1, "lastfm", Delivery{Status: Delivered}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetDelivery returned %v", err)
+	}
+}
+
+func TestMark(t *testing.T) {
+	s, _ := openStore(t)
+	if id, err := s.Marked("digest"); err != nil || id != 0 {
+		t.Errorf("unset mark is %d, %v", id, err)
+	}
+	if err := s.Mark("digest", 42); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.Marked("digest"); err != nil || id != 42 {
+		t.Errorf("mark is %d, %v, want 42", id, err)
+	}
+}
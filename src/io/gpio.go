@@ -68,6 +68,10 @@ func New() {
 }
 
 func Kill() {
+	if simulated {
+		return
+	}
+
 	rpio.Pin(RST_PIN).Low()
 	rpio.Pin(DC_PIN).Low()
 	rpio.Pin(CS_PIN).Low()
@@ -77,9 +81,15 @@ func Kill() {
 }
 
 func GetReadPin(pin int) ReadablePin {
+	if simulated {
+		return getSimPin(pin)
+	}
 	return ReadablePinPatch{rpio.Pin(pin)}
 }
 
 func GetWritePin(pin int) WriteablePin {
+	if simulated {
+		return getSimPin(pin)
+	}
 	return rpio.Pin(pin)
 }
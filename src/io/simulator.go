@@ -0,0 +1,66 @@
+package io
+
+import (
+	"fmt"
+	"sync"
+)
+
+var simulated bool
+
+var simLock sync.Mutex
+var simPins = map[int]*SimPin{}
+
+// SimPin is an in-memory GPIO pin used when ShazPi runs without a Raspberry Pi.
+// It can be both written and read so that virtual devices can observe the
+// lines driven by the drivers (and drive lines such as BUSY or INT themselves)
+type SimPin struct {
+	lock  sync.Mutex
+	level uint8
+}
+
+func (pin *SimPin) High() {
+	pin.lock.Lock()
+	defer pin.lock.Unlock()
+	pin.level = 1
+}
+
+func (pin *SimPin) Low() {
+	pin.lock.Lock()
+	defer pin.lock.Unlock()
+	pin.level = 0
+}
+
+func (pin *SimPin) Read() uint8 {
+	pin.lock.Lock()
+	defer pin.lock.Unlock()
+	return pin.level
+}
+
+func getSimPin(pin int) *SimPin {
+	simLock.Lock()
+	defer simLock.Unlock()
+
+	p, ok := simPins[pin]
+	if !ok {
+		p = &SimPin{}
+		simPins[pin] = p
+	}
+	return p
+}
+
+// NewSimulator replaces New when no Raspberry Pi is available. Every pin
+// returned by GetReadPin and GetWritePin is then backed by memory
+func NewSimulator() {
+	simulated = true
+
+	// BUSY is low when the panel is idle, INT is active low on the GT1151
+	getSimPin(BUSY_PIN).Low()
+	getSimPin(INT_PIN).High()
+	getSimPin(PWR_PIN).High()
+	fmt.Println("Init done (simulator)")
+}
+
+// Simulated reports whether the pins are backed by the simulator
+func Simulated() bool {
+	return simulated
+}
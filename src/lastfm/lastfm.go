@@ -0,0 +1,188 @@
+package lastfm
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"shazammini/src/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// Error is an error answered by Last.fm
+type Error struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Last.fm error %d: %s", e.Code, e.Message)
+}
+
+// Temporary tells whether sending the same call later may succeed
+func (e *Error) Temporary() bool {
+	switch e.Code {
+	case 11, 16, 29: // service offline, temporarily unavailable, rate limit exceeded
+		return true
+	}
+	return false
+}
+
+// Client calls the Last.fm API. Every call is signed with the shared secret
+// of the API account, calls on behalf of a user also need its session key
+type Client struct {
+	URL        string
+	APIKey     string
+	Secret     string
+	SessionKey string
+	HTTP       *http.Client
+}
+
+// Scrobble is a track listened to
+type Scrobble struct {
+	Artist string
+	Track  string
+	Album  string
+	At     time.Time
+}
+
+// Scrobble records that the track was listened to
+func (c *Client) Scrobble(s Scrobble) error {
+	if c.SessionKey == "" {
+		return errors.New("no Last.fm session, run the lastfm auth command")
+	}
+	params := url.Values{
+		"method":    {"track.scrobble"},
+		"artist":    {s.Artist},
+		"track":     {s.Track},
+		"timestamp": {strconv.FormatInt(s.At.Unix(), 10)},
+		"sk":        {c.SessionKey},
+	}
+	if s.Album != "" {
+		params.Set("album", s.Album)
+	}
+	return c.call(params, nil)
+}
+
+// Token starts the authorization of the application by a user
+func (c *Client) Token() (string, error) {
+	var res struct {
+		Token string `json:"token"`
+	}
+	err := c.call(url.Values{"method": {"auth.getToken"}}, &res)
+	return res.Token, err
+}
+
+// AuthURL is the page where the user allows the application to scrobble
+func (c *Client) AuthURL(token string) string {
+	return "https://www.last.fm/api/auth/?" + url.Values{"api_key": {c.APIKey}, "token": {token}}.Encode()
+}
+
+// Session exchanges a token the user authorized for a session key, which does not expire
+func (c *Client) Session(token string) (string, error) {
+	var res struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	if err := c.call(url.Values{"method": {"auth.getSession"}, "token": {token}}, &res); err != nil {
+		return "", err
+	}
+	return res.Session.Key, nil
+}
+
+// sign computes the signature of the parameters, their names and values
+// concatenated in the order of the names followed by the secret
+func (c *Client) sign(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+	b.WriteString(c.Secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Client) call(params url.Values, out interface{}) error {
+	params.Set("api_key", c.APIKey)
+	params.Set("api_sig", c.sign(params))
+	params.Set("format", "json")
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.PostForm(c.URL, params)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	// errors come with a status code or not depending on the method
+	apiErr := &Error{}
+	if json.Unmarshal(data, apiErr) == nil && apiErr.Code != 0 {
+		return apiErr
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+type session struct {
+	Key string `toml:"key"`
+}
+
+// LoadSession reads the session key saved by SaveSession, it is empty when
+// the user has not authorized the application yet
+func LoadSession(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	s := session{}
+	if err := toml.Unmarshal(data, &s); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return s.Key, nil
+}
+
+// SaveSession saves the session key, readable by the owner only
+func SaveSession(path, key string) error {
+	data, err := toml.Marshal(session{Key: key})
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,190 @@
+package mail
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is what the SMTP stand-in was sent
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts a single conversation without encryption or login.
+// Recipients listed in refuse are refused with their code
+func smtpStandIn(t *testing.T, refuse map[string]string) (*Client, <-chan received) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	done := make(chan received, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		msg := received{}
+		reply("220 localhost ESMTP stand-in")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				msg.from = line[len("MAIL FROM:"):]
+				reply("250 OK")
+			case "RCPT":
+				to := line[len("RCPT TO:"):]
+				if code, ok := refuse[to]; ok {
+					reply(code + " refused")
+					continue
+				}
+				msg.to = append(msg.to, to)
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(line, "."))
+				}
+				msg.data = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				done <- msg
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &Client{Host: host, Port: p, From: "ShazPi <shazpi@example.com>", Security: None, Timeout: 5 * time.Second}, done
+}
+
+func TestSend(t *testing.T) {
+	client, done := smtpStandIn(t, nil)
+	err := client.Send(Message{
+		To:      []string{"me@example.com", "Other <other@example.com>"},
+		Subject: "Déjà vu – 2 songs",
+		Text:    "Plain text with a line longer than seventy-six characters so it must be wrapped by the encoder.",
+		HTML:    "<p>Déjà <b>vu</b></p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got received
+	select {
+	case got = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the conversation did not end")
+	}
+	if got.from != "<shazpi@example.com>" {
+		t.Errorf("got MAIL FROM %s", got.from)
+	}
+	if strings.Join(got.to, " ") != "<me@example.com> <other@example.com>" {
+		t.Errorf("got RCPT TO %v", got.to)
+	}
+
+	msg, err := netmail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawSubject := msg.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?utf-8?q?") {
+		t.Errorf("subject %q is not Q-encoded", rawSubject)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	if err != nil || subject != "Déjà vu – 2 songs" {
+		t.Errorf("got subject %q, %v", subject, err)
+	}
+	if to := msg.Header.Get("To"); to != "me@example.com, Other <other@example.com>" {
+		t.Errorf("got To %q", to)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got Content-Type %q", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Plain text with a line longer than seventy-six characters so it must be wrapped by the encoder."},
+		{"text/html; charset=utf-8", "<p>Déjà <b>vu</b></p>"},
+	}
+	for _, w := range want {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if ct := part.Header.Get("Content-Type"); ct != w.contentType {
+			t.Errorf("got part %q, want %q", ct, w.contentType)
+		}
+		if string(body) != w.body {
+			t.Errorf("got %q, want %q", body, w.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("got more than two parts: %v", err)
+	}
+}
+
+func TestSendRefused(t *testing.T) {
+	tests := []struct {
+		code      string
+		permanent bool
+	}{
+		{"550", true},
+		{"451", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			client, _ := smtpStandIn(t, map[string]string{"<me@example.com>": tt.code})
+			err := client.Send(Message{To: []string{"me@example.com"}, Subject: "Hi", Text: "Hi"})
+			if err == nil {
+				t.Fatal("refused recipient accepted")
+			}
+			if got := Permanent(err); got != tt.permanent {
+				t.Errorf("got permanent %v, want %v: %v", got, tt.permanent, err)
+			}
+		})
+	}
+}
+
+func TestSendNoRecipient(t *testing.T) {
+	if err := (&Client{Host: "localhost", Security: None}).Send(Message{Subject: "Hi"}); err == nil {
+		t.Error("message without recipient sent")
+	}
+}
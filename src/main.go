@@ -1,12 +1,18 @@
 package main
 
 import (
-
-	// "shazammini/src/microphone"
-
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"shazammini/src/api"
 	"shazammini/src/commands"
+	"shazammini/src/display"
 	"shazammini/src/io"
+	"shazammini/src/microphone"
+	"shazammini/src/mqtt"
 	"shazammini/src/structs"
+	"shazammini/src/utils"
 	"time"
 
 	"github.com/d2r2/go-logger"
@@ -14,9 +20,42 @@ import (
 )
 
 func main() {
+	simulate := flag.Bool("sim", false, "run without a Raspberry Pi: virtual pins, panel (temp/panel.png) and touch screen (stdin)")
+	wavFile := flag.String("wav", "", "WAV file the simulated microphone records from")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+commandsUsage)
+	}
+	flag.Parse()
+
 	// log.SetFlags(log.LstdFlags | log.Lshortfile)
 	logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
-	io.New()
+
+	cfg := api.LoadConfig()
+	utils.ConnectivityURL = cfg.ConnectivityURL()
+
+	if flag.NArg() > 0 {
+		runCommand(cfg, flag.Args())
+		return
+	}
+
+	if *wavFile == "" {
+		*wavFile = cfg.Simulator.WAV
+	}
+
+	if err := os.MkdirAll("temp", 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	if *simulate || cfg.Simulator.Enabled {
+		if *wavFile == "" {
+			log.Fatal("The simulator records from a WAV file, set it with -wav or [Simulator] wav in creds.toml")
+		}
+		io.NewSimulator()
+	} else {
+		io.New()
+	}
 	defer io.Kill()
 
 	master := gobot.NewMaster()
@@ -25,22 +64,41 @@ func main() {
 		PlayChannel:     make(chan bool),
 		RecordChannel:   make(chan time.Duration),
 		FetchAPI:        make(chan bool),
-		DisplayResult:   make(chan structs.Track),
+		DisplayResult:   make(chan structs.Recognition),
 		DisplayRecord:   make(chan bool),
 		DisplayThinking: make(chan bool),
+		DisplayMessage:  make(chan string),
+		DisplayLogin:    make(chan string),
+		Favorite:        make(chan bool),
+		Undo:            make(chan bool),
+		DisplayChoice:   make(chan []string),
+		Choose:          make(chan structs.Choice),
+		Status:          structs.NewStatusBus(),
 	}
 
-	_ = commCahnnels
-
-	// dis := display.Screen(&commCahnnels)
-	// mic := microphone.Microphone(&commCahnnels)
-	com := commands.Commands(&commCahnnels)
-	// api := api.Api(&commCahnnels)
+	dis := display.Screen(&commCahnnels)
+	mic := microphone.Microphone(&commCahnnels)
+	if io.Simulated() {
+		mic = microphone.FileMicrophone(&commCahnnels, *wavFile)
+	}
+	com := commands.Commands(&commCahnnels, cfg.CaptureDuration())
+	api := api.Api(&commCahnnels)
 
-	// master.AddRobot(dis)
-	// master.AddRobot(api)
+	master.AddRobot(dis)
+	master.AddRobot(api)
 	master.AddRobot(com)
-	// master.AddRobot(mic)
+	master.AddRobot(mic)
+	if cfg.MQTT.Broker != "" {
+		master.AddRobot(mqtt.Bridge(&commCahnnels, mqtt.Options{
+			Broker:    cfg.MQTT.Broker,
+			Username:  cfg.MQTT.Username,
+			Password:  cfg.MQTT.Password,
+			Topic:     cfg.MQTT.Topic,
+			Discovery: cfg.MQTTDiscoveryPrefix(),
+			Device:    cfg.DeviceName(),
+			Capture:   cfg.CaptureDuration(),
+		}))
+	}
 
 	master.Start()
 }
@@ -0,0 +1,74 @@
+package microphone
+
+import (
+	"log"
+	"shazammini/src/audio"
+	"shazammini/src/structs"
+	"time"
+
+	"github.com/youpy/go-wav"
+	"gobot.io/x/gobot"
+)
+
+// fileMicrophone replays a WAV file in place of the capture device. Every
+// recording continues where the previous one stopped and loops at the end
+type fileMicrophone struct {
+	path          string
+	clip          audio.Clip
+	position      int
+	started       time.Time
+	capturedAudio []int16
+}
+
+func (m *fileMicrophone) Initialise() {
+	clip, err := audio.ReadWAV(m.path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if clip.Frames() == 0 {
+		log.Fatalf("%s does not contain any audio", m.path)
+	}
+	m.clip = clip
+	log.Printf("Replaying %s (%s, %d Hz, %d channels)", m.path, clip.Duration(), clip.SampleRate, clip.Channels)
+}
+
+func (m *fileMicrophone) Kill() {}
+
+func (m *fileMicrophone) StartRecord() {
+	m.capturedAudio = []int16{}
+	m.started = time.Now()
+}
+
+func (m *fileMicrophone) StopRecord() {
+	frames := int(time.Since(m.started).Seconds() * float64(m.clip.SampleRate))
+	channels := int(m.clip.Channels)
+	for i := 0; i < frames*channels; i++ {
+		m.capturedAudio = append(m.capturedAudio, m.clip.Samples[m.position])
+		m.position = (m.position + 1) % len(m.clip.Samples)
+	}
+}
+
+func (m *fileMicrophone) SaveToWAV() {
+	channels := int(m.clip.Channels)
+	samples := make([]wav.Sample, len(m.capturedAudio)/channels)
+	for i := range samples {
+		for ch := 0; ch < channels; ch++ {
+			samples[i].Values[ch] = int(m.capturedAudio[i*channels+ch])
+		}
+	}
+	writeWAV(samples, m.clip.Channels, m.clip.SampleRate, 16)
+}
+
+// FileMicrophone is the microphone robot of the simulator, it records from the WAV file at path
+func FileMicrophone(commChannels *structs.CommChannels, path string) *gobot.Robot {
+	work := func() {
+		run(commChannels, &fileMicrophone{path: path})
+	}
+
+	robot := gobot.NewRobot("microphone",
+		work,
+	)
+
+	return robot
+
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"shazammini/src/audio"
 	"shazammini/src/structs"
 	"time"
 
@@ -53,6 +54,15 @@ func int16SliceToSampleSlice(data []int16) []wav.Sample {
 	return result
 }
 
+// recorder is a source of audio that the microphone robot can record from
+type recorder interface {
+	Initialise()
+	Kill()
+	StartRecord()
+	StopRecord()
+	SaveToWAV()
+}
+
 type microphone struct {
 	ctx           *malgo.AllocatedContext
 	deviceConfig  malgo.DeviceConfig
@@ -153,34 +163,36 @@ func (m *microphone) StopRecord() {
 }
 
 func (m *microphone) SaveToWAV() {
+	writeWAV(int16SliceToSampleSlice(m.capturedAudio),
+		uint16(m.deviceConfig.Capture.Channels),
+		m.deviceConfig.SampleRate,
+		formatToByInt(m.deviceConfig.Capture.Format))
+}
+
+func writeWAV(samples []wav.Sample, channels uint16, sampleRate uint32, bitsPerSample uint16) {
 
-	if _, err := os.Stat("temp/output.wav"); err == nil {
+	if _, err := os.Stat(audio.RecordingFile); err == nil {
 		// Delete file
-		err := os.Remove("temp/output.wav")
+		err := os.Remove(audio.RecordingFile)
 		if err != nil && !os.IsNotExist(err) {
 			log.Fatal(err)
 		}
 	}
 
-	f, err := os.Create("temp/output.wav")
+	f, err := os.Create(audio.RecordingFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 
-	waveWriter := wav.NewWriter(f,
-		uint32(len(m.capturedAudio)),
-		uint16(m.deviceConfig.Capture.Channels),
-		m.deviceConfig.SampleRate,
-		formatToByInt(m.deviceConfig.Capture.Format))
-	if err := waveWriter.WriteSamples(int16SliceToSampleSlice(m.capturedAudio)); err != nil {
+	waveWriter := wav.NewWriter(f, uint32(len(samples)), channels, sampleRate, bitsPerSample)
+	if err := waveWriter.WriteSamples(samples); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(commChannels *structs.CommChannels) {
+func run(commChannels *structs.CommChannels, mic recorder) {
 
-	mic := microphone{}
 	mic.Initialise()
 	defer mic.Kill()
 
@@ -194,6 +206,7 @@ func run(commChannels *structs.CommChannels) {
 		log.Println("Saving to file..")
 		mic.SaveToWAV()
 
+		commChannels.Status.SetState(structs.StateThinking, "")
 		commChannels.DisplayThinking <- true
 		commChannels.FetchAPI <- true
 
@@ -203,7 +216,7 @@ func run(commChannels *structs.CommChannels) {
 
 func Microphone(commChannels *structs.CommChannels) *gobot.Robot {
 	work := func() {
-		run(commChannels)
+		run(commChannels, &microphone{})
 	}
 
 	robot := gobot.NewRobot("microphone",
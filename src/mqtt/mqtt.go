@@ -0,0 +1,261 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"shazammini/src/structs"
+	"shazammini/src/utils"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"gobot.io/x/gobot"
+)
+
+// Options tell which broker to publish to and how ShazPi is named there
+type Options struct {
+	Broker    string // such as tcp://homeassistant.local:1883
+	Username  string
+	Password  string
+	Topic     string // base of the topics of this device
+	Discovery string // prefix watched by Home Assistant, empty to publish no discovery
+	Device    string
+	Capture   time.Duration // recording started from the command topic
+}
+
+// connectivityCheck is how often the internet connection is published
+const connectivityCheck = time.Minute
+
+// track is the last recognized track, as published
+type track struct {
+	Title        string    `json:"title"`
+	Artist       string    `json:"artist"`
+	ISRC         string    `json:"isrc,omitempty"`
+	CoverArt     string    `json:"cover_art,omitempty"`
+	SpotifyURI   string    `json:"spotify_uri,omitempty"`
+	RecognizedAt time.Time `json:"recognized_at"`
+}
+
+type state struct {
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+// bridge publishes the status of ShazPi and starts recordings asked on the command topic
+type bridge struct {
+	opts         Options
+	id           string
+	commChannels *structs.CommChannels
+
+	lock   sync.Mutex
+	status structs.Status // published again after reconnecting
+}
+
+var notID = regexp.MustCompile(`[^a-z0-9_]+`)
+
+func newBridge(commChannels *structs.CommChannels, opts Options) *bridge {
+	id := strings.Trim(notID.ReplaceAllString(strings.ToLower(opts.Device), "_"), "_")
+	if id == "" {
+		id = "shazpi"
+	}
+	if opts.Topic == "" {
+		opts.Topic = "shazpi/" + id
+	}
+	opts.Topic = strings.TrimSuffix(opts.Topic, "/")
+	return &bridge{opts: opts, id: id, commChannels: commChannels}
+}
+
+func (b *bridge) topic(name string) string {
+	return b.opts.Topic + "/" + name
+}
+
+func (b *bridge) clientOptions() *paho.ClientOptions {
+	return paho.NewClientOptions().
+		AddBroker(b.opts.Broker).
+		SetClientID("shazpi-"+b.id).
+		SetUsername(b.opts.Username).
+		SetPassword(b.opts.Password).
+		SetAutoReconnect(true).
+		SetWill(b.topic("availability"), "offline", 1, true).
+		SetOnConnectHandler(b.connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Println("Lost MQTT broker:", err)
+		})
+}
+
+// connected runs on every connection, the broker may have forgotten everything
+func (b *bridge) connected(client paho.Client) {
+	log.Println("Connected to MQTT broker", b.opts.Broker)
+	if token := client.Subscribe(b.topic("record"), 1, b.record); token.Wait() && token.Error() != nil {
+		log.Println("Could not subscribe to MQTT commands:", token.Error())
+	}
+	if b.opts.Discovery != "" {
+		b.discover(client)
+	}
+	b.publish(client, b.topic("availability"), "online")
+
+	b.lock.Lock()
+	status := b.status
+	b.lock.Unlock()
+	b.publishState(client, status)
+	b.publishTrack(client, status.Track)
+	b.publishConnectivity(client)
+}
+
+// record starts a recording, unless one is already being recorded or recognized
+func (b *bridge) record(paho.Client, paho.Message) {
+	b.lock.Lock()
+	busy := b.status.State == structs.StateRecording || b.status.State == structs.StateThinking
+	b.lock.Unlock()
+	if busy {
+		log.Println("Ignoring MQTT record command, already recording")
+		return
+	}
+	log.Println("Recording on MQTT command")
+	go b.commChannels.StartRecording(b.opts.Capture)
+}
+
+func (b *bridge) publish(client paho.Client, name string, payload interface{}) {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	default:
+		var err error
+		if data, err = json.Marshal(p); err != nil {
+			log.Println("Could not encode MQTT payload:", err)
+			return
+		}
+	}
+	if token := client.Publish(name, 1, true, data); token.Wait() && token.Error() != nil {
+		log.Printf("Could not publish %s: %v", name, token.Error())
+	}
+}
+
+func (b *bridge) publishState(client paho.Client, status structs.Status) {
+	b.publish(client, b.topic("state"), state{State: status.State, Message: status.Message})
+}
+
+func (b *bridge) publishTrack(client paho.Client, rec *structs.Recognition) {
+	if rec == nil {
+		return
+	}
+	b.publish(client, b.topic("track"), track{
+		Title:        rec.Track.Title,
+		Artist:       rec.Track.Subtitle,
+		ISRC:         rec.Track.Isrc,
+		CoverArt:     rec.Track.Image.CoverArt,
+		SpotifyURI:   rec.SpotifyURI,
+		RecognizedAt: time.UnixMilli(rec.Timestamp),
+	})
+}
+
+func (b *bridge) publishConnectivity(client paho.Client) {
+	online := "OFF"
+	if utils.Connected() {
+		online = "ON"
+	}
+	b.publish(client, b.topic("connectivity"), online)
+}
+
+// discover describes the entities of the device to Home Assistant
+func (b *bridge) discover(client paho.Client) {
+	device := map[string]interface{}{
+		"identifiers":  []string{"shazpi_" + b.id},
+		"name":         b.opts.Device,
+		"manufacturer": "ShazPi",
+		"model":        "ShazPi",
+	}
+	entity := func(component, object string, config map[string]interface{}) {
+		config["unique_id"] = "shazpi_" + b.id + "_" + object
+		config["object_id"] = "shazpi_" + b.id + "_" + object
+		config["availability_topic"] = b.topic("availability")
+		config["device"] = device
+		b.publish(client, b.opts.Discovery+"/"+component+"/shazpi_"+b.id+"/"+object+"/config", config)
+	}
+
+	entity("sensor", "state", map[string]interface{}{
+		"name":                  "State",
+		"icon":                  "mdi:music-circle",
+		"state_topic":           b.topic("state"),
+		"value_template":        "{{ value_json.state }}",
+		"json_attributes_topic": b.topic("state"),
+	})
+	entity("sensor", "track", map[string]interface{}{
+		"name":                  "Last track",
+		"icon":                  "mdi:music-note",
+		"state_topic":           b.topic("track"),
+		"value_template":        "{{ value_json.title }} – {{ value_json.artist }}",
+		"json_attributes_topic": b.topic("track"),
+	})
+	entity("binary_sensor", "connectivity", map[string]interface{}{
+		"name":         "Internet",
+		"device_class": "connectivity",
+		"state_topic":  b.topic("connectivity"),
+		"payload_on":   "ON",
+		"payload_off":  "OFF",
+	})
+	entity("button", "record", map[string]interface{}{
+		"name":          "Record",
+		"icon":          "mdi:microphone",
+		"command_topic": b.topic("record"),
+		"payload_press": "record",
+	})
+}
+
+func run(commChannels *structs.CommChannels, opts Options) {
+	b := newBridge(commChannels, opts)
+	statuses := commChannels.Status.Subscribe()
+	b.status = <-statuses
+	client := paho.NewClient(b.clientOptions())
+
+	// the broker may start after ShazPi, keep trying
+	for {
+		token := client.Connect()
+		if token.Wait() && token.Error() == nil {
+			break
+		}
+		log.Println("Could not connect to MQTT broker:", token.Error())
+		time.Sleep(10 * time.Second)
+	}
+
+	go func() {
+		for range time.Tick(connectivityCheck) {
+			if client.IsConnected() {
+				b.publishConnectivity(client)
+			}
+		}
+	}()
+
+	for status := range statuses {
+		b.lock.Lock()
+		previous := b.status
+		b.status = status
+		b.lock.Unlock()
+
+		if !client.IsConnected() {
+			continue
+		}
+		b.publishState(client, status)
+		// the track stays the same while the state changes
+		if status.Track != previous.Track {
+			b.publishTrack(client, status.Track)
+		}
+	}
+}
+
+// Bridge publishes the state of ShazPi to an MQTT broker, with Home Assistant
+// discovery, and records when asked on the command topic
+func Bridge(commChannels *structs.CommChannels, opts Options) *gobot.Robot {
+	work := func() {
+		run(commChannels, opts)
+	}
+
+	robot := gobot.NewRobot("mqtt",
+		work,
+	)
+
+	return robot
+}
@@ -0,0 +1,295 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"shazammini/src/structs"
+	"shazammini/src/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// brokerStandIn is an MQTT 3.1.1 broker with a single client, enough for
+// the bridge: it keeps the retained messages and the last will, and sends
+// the client what it publishes on the topics it subscribed to
+type brokerStandIn struct {
+	listener net.Listener
+
+	lock      sync.Mutex
+	conn      net.Conn
+	retained  map[string]string
+	subscribe []string
+	will      [2]string // topic and message
+	changed   chan bool
+}
+
+func newBrokerStandIn(t *testing.T) *brokerStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &brokerStandIn{listener: l, retained: map[string]string{}, changed: make(chan bool, 1)}
+	t.Cleanup(func() {
+		l.Close()
+		b.lock.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		b.lock.Unlock()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.lock.Lock()
+			b.conn = conn
+			b.lock.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *brokerStandIn) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func readString(data []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(data))
+	return string(data[2 : 2+n]), data[2+n:]
+}
+
+func appendString(data []byte, s string) []byte {
+	data = binary.BigEndian.AppendUint16(data, uint16(len(s)))
+	return append(data, s...)
+}
+
+func packet(kind byte, body []byte) []byte {
+	out := []byte{kind}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 128
+		}
+		out = append(out, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func (b *brokerStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			digit, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(digit&127) * multiplier
+			multiplier *= 128
+			if digit&128 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch kind >> 4 {
+		case 1: // CONNECT
+			_, rest := readString(body)
+			flags := rest[1]
+			_, rest = readString(rest[4:]) // client ID
+			if flags&0x04 != 0 {
+				topic, rest2 := readString(rest)
+				message, _ := readString(rest2)
+				b.lock.Lock()
+				b.will = [2]string{topic, message}
+				b.lock.Unlock()
+			}
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			qos := kind >> 1 & 3
+			topic, rest := readString(body)
+			if qos > 0 {
+				conn.Write(packet(0x40, rest[:2]))
+				rest = rest[2:]
+			}
+			if kind&1 != 0 {
+				b.lock.Lock()
+				b.retained[topic] = string(rest)
+				b.lock.Unlock()
+				select {
+				case b.changed <- true:
+				default:
+				}
+			}
+		case 8: // SUBSCRIBE
+			id, rest := body[:2], body[2:]
+			ack := append([]byte{}, id...)
+			for len(rest) > 0 {
+				var topic string
+				topic, rest = readString(rest)
+				rest = rest[1:]
+				b.lock.Lock()
+				b.subscribe = append(b.subscribe, topic)
+				b.lock.Unlock()
+				ack = append(ack, 0)
+			}
+			conn.Write(packet(0x90, ack))
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// publish sends the client a message on a topic it subscribed to
+func (b *brokerStandIn) publish(t *testing.T, topic, message string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	subscribed := false
+	for _, s := range b.subscribe {
+		subscribed = subscribed || s == topic
+	}
+	if !subscribed {
+		t.Fatalf("the client did not subscribe to %s but to %v", topic, b.subscribe)
+	}
+	b.conn.Write(packet(0x30, append(appendString(nil, topic), message...)))
+}
+
+// wait returns the retained message of the topic once ok accepts it
+func (b *brokerStandIn) wait(t *testing.T, topic string, ok func(string) bool) string {
+	deadline := time.After(5 * time.Second)
+	for {
+		b.lock.Lock()
+		message, found := b.retained[topic]
+		b.lock.Unlock()
+		if found && ok(message) {
+			return message
+		}
+		select {
+		case <-b.changed:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("%s is %q", topic, message)
+		}
+	}
+}
+
+func stateIs(want string) func(string) bool {
+	return func(message string) bool {
+		s := state{}
+		return json.Unmarshal([]byte(message), &s) == nil && s.State == want
+	}
+}
+
+func TestBridge(t *testing.T) {
+	internet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer internet.Close()
+	utils.ConnectivityURL = internet.URL
+
+	broker := newBrokerStandIn(t)
+	commChannels := &structs.CommChannels{
+		RecordChannel: make(chan time.Duration, 2),
+		DisplayRecord: make(chan bool, 2),
+		Status:        structs.NewStatusBus(),
+	}
+	go run(commChannels, Options{
+		Broker:    broker.url(),
+		Discovery: "homeassistant",
+		Device:    "Kitchen Pi",
+		Capture:   7 * time.Second,
+	})
+
+	const base = "shazpi/kitchen_pi/"
+	broker.wait(t, base+"availability", func(m string) bool { return m == "online" })
+	broker.wait(t, base+"state", stateIs(structs.StateIdle))
+	broker.wait(t, base+"connectivity", func(m string) bool { return m == "ON" })
+	broker.lock.Lock()
+	if broker.will != [2]string{base + "availability", "offline"} {
+		t.Errorf("got last will %v", broker.will)
+	}
+	broker.lock.Unlock()
+
+	for _, entity := range []string{"sensor/shazpi_kitchen_pi/state", "sensor/shazpi_kitchen_pi/track", "binary_sensor/shazpi_kitchen_pi/connectivity", "button/shazpi_kitchen_pi/record"} {
+		message := broker.wait(t, "homeassistant/"+entity+"/config", func(string) bool { return true })
+		config := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(message), &config); err != nil {
+			t.Fatal(err)
+		}
+		if config["availability_topic"] != base+"availability" || !strings.HasPrefix(config["unique_id"].(string), "shazpi_kitchen_pi_") {
+			t.Errorf("got %s config %s", entity, message)
+		}
+	}
+
+	// a recognition, then back to idle
+	commChannels.Status.SetState(structs.StateThinking, "")
+	broker.wait(t, base+"state", stateIs(structs.StateThinking))
+	rec := structs.Recognition{
+		Track:     structs.Track{Title: "Get Lucky", Subtitle: "Daft Punk", Isrc: "USQX91300108"},
+		Timestamp: time.Now().UnixMilli(),
+	}
+	commChannels.Status.SetResult(rec)
+	broker.wait(t, base+"state", stateIs(structs.StateResult))
+	message := broker.wait(t, base+"track", func(string) bool { return true })
+	got := track{}
+	if err := json.Unmarshal([]byte(message), &got); err != nil || got.Title != "Get Lucky" || got.Artist != "Daft Punk" {
+		t.Errorf("got track %s", message)
+	}
+	// the api finds the track on Spotify after showing the result
+	rec.SpotifyURI = "spotify:track:2Foc5Q5nqNiosCNqttzHof"
+	commChannels.Status.SetTrack(rec)
+	message = broker.wait(t, base+"track", func(m string) bool {
+		return json.Unmarshal([]byte(m), &got) == nil && got.SpotifyURI != ""
+	})
+	if got.SpotifyURI != rec.SpotifyURI || got.Title != "Get Lucky" {
+		t.Errorf("got track %s", message)
+	}
+	broker.wait(t, base+"state", stateIs(structs.StateResult))
+	commChannels.Status.Settle()
+	broker.wait(t, base+"state", stateIs(structs.StateIdle))
+
+	// the record button
+	broker.publish(t, base+"record", "record")
+	select {
+	case d := <-commChannels.RecordChannel:
+		if d != 7*time.Second {
+			t.Errorf("recording for %s", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("record command did not start a recording")
+	}
+	broker.wait(t, base+"state", stateIs(structs.StateRecording))
+
+	// ignored while recording
+	broker.publish(t, base+"record", "record")
+	select {
+	case <-commChannels.RecordChannel:
+		t.Error("second recording started while recording")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
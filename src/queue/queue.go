@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shazammini/src/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Queue is a durable first-in first-out queue stored in a directory. Every
+// item is a JSON document, optionally with an attachment such as a recording.
+// Files are written atomically and the attachment is written before the
+// document, so an item only exists once all of it is on disk
+type Queue struct {
+	dir  string
+	lock sync.Mutex
+	seq  int
+}
+
+const itemExt = ".json"
+const attachmentExt = ".blob"
+
+// Open opens the queue stored in dir, creating it if needed
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir}
+
+	// temporary files and attachments without a document were left by a crash
+	temporary, _ := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	for _, path := range temporary {
+		os.Remove(path)
+	}
+
+	ids, err := q.IDs()
+	if err != nil {
+		return nil, err
+	}
+	committed := map[string]bool{}
+	for _, id := range ids {
+		committed[id] = true
+	}
+	attachments, _ := filepath.Glob(filepath.Join(dir, "*"+attachmentExt))
+	for _, path := range attachments {
+		if !committed[strings.TrimSuffix(filepath.Base(path), attachmentExt)] {
+			os.Remove(path)
+		}
+	}
+	return q, nil
+}
+
+// Push appends an item to the queue and returns its ID
+func (q *Queue) Push(item interface{}, attachment []byte) (string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	q.seq++
+	id := fmt.Sprintf("%020d-%04d", time.Now().UnixNano(), q.seq%10000)
+
+	if attachment != nil {
+		if err := utils.WriteFileAtomic(q.Attachment(id), attachment, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(q.dir, id+itemExt), data, 0644); err != nil {
+		os.Remove(q.Attachment(id))
+		return "", err
+	}
+	return id, nil
+}
+
+// Update replaces the document of an item, it keeps its place in the queue
+// and its attachment
+func (q *Queue) Update(id string, item interface{}) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(q.dir, id+itemExt), data, 0644)
+}
+
+// IDs returns the items in the queue, oldest first
+func (q *Queue) IDs() ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, itemExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, itemExt))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Len returns the number of items in the queue
+func (q *Queue) Len() int {
+	ids, _ := q.IDs()
+	return len(ids)
+}
+
+// Load decodes the item with the given ID into item
+func (q *Queue) Load(id string, item interface{}) error {
+	data, err := os.ReadFile(filepath.Join(q.dir, id+itemExt))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, item)
+}
+
+// Attachment returns the path of the attachment of an item
+func (q *Queue) Attachment(id string) string {
+	return filepath.Join(q.dir, id+attachmentExt)
+}
+
+// Remove deletes an item and its attachment
+func (q *Queue) Remove(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := os.Remove(filepath.Join(q.dir, id+itemExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(q.Attachment(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package structs
+
+import "sync"
+
+// states of ShazPi, as told to the watchers of the StatusBus
+const (
+	StateIdle      = "idle"
+	StateRecording = "recording"
+	StateThinking  = "thinking"
+	StateResult    = "result"
+	StateError     = "error"
+)
+
+// Status is what ShazPi is doing, Track is the last recognized track and
+// stays set while the state changes
+type Status struct {
+	State   string
+	Message string // what went wrong in the error state
+	Track   *Recognition
+}
+
+// StatusBus hands every change of status to its subscribers without waiting
+// for them, a slow subscriber only misses the statuses replaced since. A nil
+// bus drops everything
+type StatusBus struct {
+	lock    sync.Mutex
+	current Status
+	subs    []chan Status
+}
+
+func NewStatusBus() *StatusBus {
+	return &StatusBus{current: Status{State: StateIdle}}
+}
+
+// Subscribe returns a channel receiving the current status, then every change
+func (b *StatusBus) Subscribe() <-chan Status {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ch := make(chan Status, 1)
+	ch <- b.current
+	b.subs = append(b.subs, ch)
+	return ch
+}
+
+// SetState changes the state, message explains errors
+func (b *StatusBus) SetState(state, message string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.current.State = state
+	b.current.Message = message
+	b.publish()
+}
+
+// Settle goes back to idle after a result or an error, a recording started
+// since is left alone
+func (b *StatusBus) Settle() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.current.State != StateResult && b.current.State != StateError {
+		return
+	}
+	b.current.State = StateIdle
+	b.current.Message = ""
+	b.publish()
+}
+
+// SetResult shows a newly recognized track
+func (b *StatusBus) SetResult(rec Recognition) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.current = Status{State: StateResult, Track: &rec}
+	b.publish()
+}
+
+// SetTrack updates the last recognized track, such as once it was found on
+// Spotify, without changing the state
+func (b *StatusBus) SetTrack(rec Recognition) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.current.Track = &rec
+	b.publish()
+}
+
+// publish replaces the status a subscriber has not read yet, if any
+func (b *StatusBus) publish() {
+	for _, ch := range b.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- b.current
+	}
+}
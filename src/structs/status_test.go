@@ -0,0 +1,18 @@
+package structs
+
+import "testing"
+
+func TestSettleKeepsRecording(t *testing.T) {
+	bus := NewStatusBus()
+	statuses := bus.Subscribe()
+	bus.SetState(StateRecording, "")
+	bus.Settle()
+	if s := <-statuses; s.State != StateRecording {
+		t.Errorf("settled to %s while recording", s.State)
+	}
+	bus.SetState(StateError, "No match found")
+	bus.Settle()
+	if s := <-statuses; s.State != StateIdle || s.Message != "" {
+		t.Errorf("got %+v after an error, want idle", s)
+	}
+}
@@ -8,9 +8,47 @@ type CommChannels struct {
 	PlayChannel     chan bool
 	RecordChannel   chan time.Duration
 	FetchAPI        chan bool
-	DisplayResult   chan Track
+	DisplayResult   chan Recognition
 	DisplayThinking chan bool
 	DisplayRecord   chan bool
+	DisplayMessage  chan string
+	DisplayLogin    chan string // address to open on a phone to log in to Spotify
+	Favorite        chan bool   // long press, saves the result on screen to the favorites
+	Undo            chan bool   // tap right after a track was added, removes it again
+	DisplayChoice   chan []string
+	Choose          chan Choice // asks the touch screen which option on the display was picked
+	Status          *StatusBus  // what ShazPi is doing, for the watchers outside the device
+}
+
+// Choice waits for a tap on one of the rows of the display, the number of the
+// row is sent on Reply, which must be buffered so that the touch screen never
+// blocks. Taps after Deadline are not choices anymore
+type Choice struct {
+	Options  int
+	Deadline time.Time
+	Reply    chan int
+}
+
+// StartRecording shows the recording screen and asks the microphone to record for d
+func (c *CommChannels) StartRecording(d time.Duration) {
+	c.Status.SetState(StateRecording, "")
+	c.DisplayRecord <- true
+	c.RecordChannel <- d
+}
+
+// Recognition is what a recognizer found in a recording, independently of the backend used
+type Recognition struct {
+	Track      Track   `json:"track"`
+	Matches    []Match `json:"matches"`
+	Timestamp  int64   `json:"timestamp"` // milliseconds since epoch
+	Timezone   string  `json:"timezone"`
+	TagID      string  `json:"tagid"`
+	Confidence float64 `json:"confidence"` // between 0 and 1
+	Backend    string  `json:"backend"`
+	Votes      int     `json:"votes"`                 // windows of the recording that agreed on the track
+	Windows    int     `json:"windows"`               // windows of the recording that were recognized
+	SpotifyURI string  `json:"spotify_uri,omitempty"` // track found on Spotify, once searched
+	ID         uint64  `json:"id,omitempty"`          // entry of the recognition in the history
 }
 
 type Match struct {
@@ -26,13 +64,13 @@ type Track struct {
 	Key      string      `json:"key"`
 	Title    string      `json:"title"`
 	Subtitle string      `json:"subtitle"`
-	Image    TrackImages `json:"image"`
+	Image    TrackImages `json:"images"`
 	Share    Share       `json:"share"`
 	Hub      Hub         `json:"hub"`
 	Url      string      `json:"url"`
-	Artists  []Artist    `json:"artist"`
+	Artists  []Artist    `json:"artists"`
 	Isrc     string      `json:"isrc"`
-	Genre    Genre       `json:"genre"`
+	Genre    Genre       `json:"genres"`
 	// Urlparams   Urlparams   `json:"urlparams"`
 	MyShazam    MyShazam `json:"myshazam"`
 	Albumadamid string   `json:"albumadamid"`
@@ -0,0 +1,15 @@
+package utils
+
+import "net/http"
+
+// ConnectivityURL answers 204 when the internet is reachable
+var ConnectivityURL = "http://clients3.google.com/generate_204"
+
+func Connected() (ok bool) {
+	res, err := http.Get(ConnectivityURL)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return true
+}